@@ -1,13 +1,22 @@
 package imap
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ErrClosed is returned by calls on a connection that has been closed,
+// either by Close or Logout or because the connection failed.
+var ErrClosed = os.NewError("imap: connection closed")
+
 func check(err os.Error) {
 	if err != nil {
 		panic(err)
@@ -20,39 +29,88 @@ type IMAP struct {
 
 	Unsolicited chan interface{}
 
+	// WrapTLS, if not nil, is applied to the connection once StartTLS
+	// has upgraded it, e.g. to log the decrypted traffic.
+	WrapTLS func(net.Conn) net.Conn
+
 	// Background thread.
-	r *reader
-	w io.Writer
+	conn net.Conn
+	r    *reader
+
+	// Server capabilities, if known.  Kept up to date from CAPABILITY
+	// responses and response codes as they arrive.
+	capsLock sync.Mutex
+	caps     *ResponseCapabilities
+	enabled  map[string]bool
 
 	pendingLock sync.Mutex
 	pendingTag  tag
 	pendingChan chan interface{}
+	pendingTLS  *tls.Config
+	usesTLS     bool
+	closed      bool
 }
 
-func New(r io.Reader, w io.Writer) *IMAP {
+func New(conn net.Conn) *IMAP {
 	return &IMAP{
-		r:       &reader{newParser(r)},
-		w:       w,
+		conn:    conn,
+		r:       newReader(conn),
+		usesTLS: isTLS(conn),
+	}
+}
+
+// isTLS reports whether conn is a TLS connection, looking through
+// wrappers that expose the connection they wrap with a NetConn method.
+func isTLS(conn net.Conn) bool {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			return true
+		case interface {
+			NetConn() net.Conn
+		}:
+			conn = c.NetConn()
+		default:
+			return false
+		}
 	}
+	panic("not reached")
 }
 
 func (imap *IMAP) Start() (string, os.Error) {
-	tag, r, err := imap.r.readResponse()
+	// The greeting is read by hand rather than with readResponse, so
+	// that a response code like CAPABILITY doesn't replace the text.
+	tag, err := imap.r.readTag()
 	if err != nil {
 		return "", err
 	}
 	if tag != untagged {
 		return "", fmt.Errorf("expected untagged server hello. got %q", tag)
 	}
-	resp := r.(*ResponseStatus)
+	command, err := imap.r.readToken()
+	if err != nil {
+		return "", err
+	}
+	if command == "BYE" {
+		text, _ := imap.r.readToEOL()
+		return "", fmt.Errorf("imap: server refused connection: %s", text)
+	}
+	resp, err := imap.r.readStatus(command)
+	if err != nil {
+		return "", err
+	}
 	if resp.status != OK {
-		return "", &IMAPError{resp.status, resp.text}
+		return "", &IMAPError{resp.status, resp.code, resp.text}
+	}
+	if caps, ok := resp.code.(*ResponseCapabilities); ok {
+		imap.setCapabilities(caps)
 	}
 
 	go func() {
-		err := imap.readLoop()
-		// XXX what to do with an error on the read thread?
-		panic(err)
+		// XXX the cause of a failure is dropped; callers only see
+		// ErrClosed.
+		imap.readLoop()
+		imap.shutdown()
 	}()
 
 	return resp.text, nil
@@ -64,29 +122,75 @@ func (imap *IMAP) Send(ch chan interface{}, format string, args ...interface{})
 
 	toSend := []byte(fmt.Sprintf("a%d %s\r\n", int(tag), fmt.Sprintf(format, args...)))
 
+	imap.pendingLock.Lock()
+	if imap.closed {
+		imap.pendingLock.Unlock()
+		return ErrClosed
+	}
 	if ch != nil {
-		imap.pendingLock.Lock()
 		imap.pendingTag = tag
 		imap.pendingChan = ch
-		imap.pendingLock.Unlock()
 	}
+	imap.pendingLock.Unlock()
 
-	_, err := imap.w.Write(toSend)
+	_, err := imap.conn.Write(toSend)
 	return err
 }
 
+// SendSync sends a command and waits for its result.  Literals in the
+// command, written as "{n}\r\n" followed by n bytes, are sent as the
+// server asks for them; they must not contain CRLF themselves.
 func (imap *IMAP) SendSync(format string, args ...interface{}) (*ResponseStatus, os.Error) {
+	parts := strings.Split(fmt.Sprintf(format, args...), "\r\n")
 	ch := make(chan interface{}, 1)
-	err := imap.Send(ch, format, args...)
+	err := imap.Send(ch, "%s", parts[0])
 	if err != nil {
 		return nil, err
 	}
+	for _, part := range parts[1:] {
+		err = imap.waitContinuation(ch)
+		if err != nil {
+			return nil, err
+		}
+		_, err = imap.conn.Write([]byte(part + "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return imap.readSync(ch)
+}
 
+// waitContinuation waits for the server to ask for a literal.  It
+// fails if the server refuses the command instead.
+func (imap *IMAP) waitContinuation(ch chan interface{}) os.Error {
+	for {
+		r, ok := <-ch
+		if !ok {
+			return ErrClosed
+		}
+		switch r := r.(type) {
+		case *ResponseContinuation:
+			return nil
+		case *ResponseStatus:
+			return &IMAPError{r.status, r.code, r.text}
+		default:
+			imap.Unsolicited <- r
+		}
+	}
+	panic("not reached")
+}
+
+// readSync collects the responses to the command pending on ch, up
+// to and including its status response.
+func (imap *IMAP) readSync(ch chan interface{}) (*ResponseStatus, os.Error) {
 	var response *ResponseStatus
 	extra := make([]interface{}, 0)
 L:
 	for {
-		r := <-ch
+		r, ok := <-ch
+		if !ok {
+			return nil, ErrClosed
+		}
 		switch r := r.(type) {
 		case *ResponseStatus:
 			response = r
@@ -101,7 +205,7 @@ L:
 	}
 	// XXX callers discard unsolicited responses if this is not OK
 	if response.status != OK {
-		return response, &IMAPError{response.status, response.text}
+		return response, &IMAPError{response.status, response.code, response.text}
 	}
 	return response, nil
 }
@@ -111,8 +215,16 @@ func (imap *IMAP) Auth(user string, pass string) (string, []string, os.Error) {
 	if err != nil {
 		return "", nil, err
 	}
+	return resp.text, imap.loginCapabilities(resp), nil
+}
 
+// loginCapabilities returns the capabilities sent along with a
+// successful login, if any, and passes other data on to Unsolicited.
+func (imap *IMAP) loginCapabilities(resp *ResponseStatus) []string {
 	var caps []string
+	if code, ok := resp.code.(*ResponseCapabilities); ok {
+		caps = code.Capabilities
+	}
 	for _, extra := range resp.extra {
 		switch extra := extra.(type) {
 		case *ResponseCapabilities:
@@ -121,57 +233,501 @@ func (imap *IMAP) Auth(user string, pass string) (string, []string, os.Error) {
 			imap.Unsolicited <- extra
 		}
 	}
-	return resp.text, caps, nil
+	if caps == nil {
+		// Capabilities may change after login, so forget the old ones.
+		imap.setCapabilities(nil)
+	}
+	return caps
+}
+
+func (imap *IMAP) setCapabilities(caps *ResponseCapabilities) {
+	imap.capsLock.Lock()
+	imap.caps = caps
+	imap.capsLock.Unlock()
+}
+
+// Capabilities returns the server capabilities last seen, or nil if
+// they aren't known.
+func (imap *IMAP) Capabilities() *ResponseCapabilities {
+	imap.capsLock.Lock()
+	defer imap.capsLock.Unlock()
+	return imap.caps
+}
+
+// Capability asks the server for its capabilities.
+func (imap *IMAP) Capability() (*ResponseCapabilities, os.Error) {
+	resp, err := imap.SendSync("CAPABILITY")
+	if err != nil {
+		return nil, err
+	}
+
+	var caps *ResponseCapabilities
+	for _, extra := range resp.extra {
+		switch extra := extra.(type) {
+		case *ResponseCapabilities:
+			caps = extra
+		default:
+			imap.Unsolicited <- extra
+		}
+	}
+	if caps == nil {
+		return nil, os.NewError("imap: no CAPABILITY response")
+	}
+	return caps, nil
+}
+
+// HasCapability reports whether the server advertises a capability,
+// like "IDLE".  It only asks the server if the capabilities aren't
+// already known.
+func (imap *IMAP) HasCapability(name string) (bool, os.Error) {
+	caps := imap.Capabilities()
+	if caps == nil {
+		var err os.Error
+		caps, err = imap.Capability()
+		if err != nil {
+			return false, err
+		}
+	}
+	return caps.Has(name), nil
+}
+
+// Enable turns on extensions that change how the server talks to the
+// client, like CONDSTORE, QRESYNC or UTF8=ACCEPT (RFC 5161).  It
+// returns the ones the server actually enabled.
+func (imap *IMAP) Enable(caps ...string) ([]string, os.Error) {
+	resp, err := imap.SendSync("ENABLE %s", strings.Join(caps, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := make([]string, 0)
+	for _, extra := range resp.extra {
+		if r, ok := extra.(*ResponseEnabled); ok {
+			enabled = append(enabled, r.Capabilities...)
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	return enabled, nil
+}
+
+// Enabled reports whether an extension has been turned on with Enable.
+func (imap *IMAP) Enabled(name string) bool {
+	imap.capsLock.Lock()
+	defer imap.capsLock.Unlock()
+	return imap.enabled[strings.ToUpper(name)]
+}
+
+// StartTLS upgrades a plaintext connection to TLS (RFC 3501 section
+// 6.2.1) and then asks the server for its new capabilities.  config
+// should name the server so that its certificate can be verified.
+// If the upgrade fails, the connection is closed rather than used
+// unencrypted.
+func (imap *IMAP) StartTLS(config *tls.Config) os.Error {
+	imap.pendingLock.Lock()
+	usesTLS := imap.usesTLS
+	imap.pendingLock.Unlock()
+	if usesTLS {
+		return os.NewError("imap: connection already uses TLS")
+	}
+	hasStartTLS, err := imap.HasCapability("STARTTLS")
+	if err != nil {
+		return err
+	}
+	if !hasStartTLS {
+		return os.NewError("imap: server does not support STARTTLS")
+	}
+
+	imap.pendingLock.Lock()
+	imap.pendingTLS = config
+	imap.pendingLock.Unlock()
+
+	ch := make(chan interface{}, 1)
+	err = imap.Send(ch, "STARTTLS")
+	if err != nil {
+		return err
+	}
+
+L:
+	for {
+		r, ok := <-ch
+		if !ok {
+			return ErrClosed
+		}
+		switch r := r.(type) {
+		case os.Error:
+			// The handshake failed.
+			return r
+		case *ResponseStatus:
+			if r.status != OK {
+				return &IMAPError{r.status, r.code, r.text}
+			}
+			break L
+		default:
+			imap.Unsolicited <- r
+		}
+	}
+
+	_, err = imap.Capability()
+	return err
+}
+
+// upgradeTLS runs the TLS handshake for StartTLS on the read thread.
+func (imap *IMAP) upgradeTLS(config *tls.Config) os.Error {
+	tlsConn := tls.Client(imap.conn, config)
+	err := tlsConn.Handshake()
+	if err != nil {
+		return err
+	}
+	var conn net.Conn = tlsConn
+	if imap.WrapTLS != nil {
+		conn = imap.WrapTLS(conn)
+	}
+	imap.pendingLock.Lock()
+	imap.conn = conn
+	imap.usesTLS = true
+	imap.pendingLock.Unlock()
+	imap.r = newReader(conn)
+	// Capabilities learned before TLS must not be trusted.
+	imap.setCapabilities(nil)
+	return nil
 }
 
 func quote(in string) string {
 	if strings.IndexAny(in, "\r\n") >= 0 {
 		panic("invalid characters in string to quote")
 	}
+	in = strings.Replace(in, "\\", "\\\\", -1)
+	in = strings.Replace(in, "\"", "\\\"", -1)
 	return "\"" + in + "\""
 }
 
-func (imap *IMAP) List(reference string, name string) ([]*ResponseList, os.Error) {
-	/* Responses:  untagged responses: LIST */
-	response, err := imap.SendSync("LIST %s %s", quote(reference), quote(name))
+// sendSimple sends a command that has no interesting untagged
+// responses, passing any it does get on to Unsolicited.
+func (imap *IMAP) sendSimple(format string, args ...interface{}) os.Error {
+	resp, err := imap.SendSync(format, args...)
+	if err != nil {
+		return err
+	}
+	for _, extra := range resp.extra {
+		imap.Unsolicited <- extra
+	}
+	return nil
+}
+
+func (imap *IMAP) list(format string, args ...interface{}) ([]*ResponseList, os.Error) {
+	/* Responses:  untagged responses: LIST or LSUB */
+	response, err := imap.SendSync(format, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	lists := make([]*ResponseList, 0)
+	byName := make(map[string]*ResponseList)
+	statuses := make([]*ResponseStatusData, 0)
 	for _, extra := range response.extra {
-		if list, ok := extra.(*ResponseList); ok {
-			lists = append(lists, list)
-		} else {
+		switch extra := extra.(type) {
+		case *ResponseList:
+			lists = append(lists, extra)
+			byName[mailboxKey(extra.Name)] = extra
+		case *ResponseStatusData:
+			statuses = append(statuses, extra)
+		default:
 			imap.Unsolicited <- extra
 		}
 	}
 
+	// With LIST-STATUS, each mailbox's STATUS follows its LIST.
+	for _, status := range statuses {
+		if list, ok := byName[mailboxKey(status.Mailbox)]; ok && list.Status == nil {
+			list.Status = status
+		} else {
+			imap.Unsolicited <- status
+		}
+	}
+
 	return lists, nil
 }
 
-// ResponseExamine contains the response to examining a mailbox.
+// mailboxKey returns name for comparing with other mailbox names: INBOX
+// is case-insensitive, so any spelling of it becomes "INBOX".
+func mailboxKey(name string) string {
+	if strings.ToUpper(name) == "INBOX" {
+		return "INBOX"
+	}
+	return name
+}
+
+func (imap *IMAP) List(reference string, name string) ([]*ResponseList, os.Error) {
+	return imap.list("LIST %s %s", quote(reference), quote(name))
+}
+
+// Lsub is like List, but only returns subscribed mailboxes.
+func (imap *IMAP) Lsub(reference string, name string) ([]*ResponseList, os.Error) {
+	return imap.list("LSUB %s %s", quote(reference), quote(name))
+}
+
+// Selection and return options for ListExtended.  See RFC 5258 and,
+// for the SPECIAL-USE options, RFC 6154.
+const (
+	ListSelectSubscribed     = "SUBSCRIBED"
+	ListSelectRemote         = "REMOTE"
+	ListSelectRecursiveMatch = "RECURSIVEMATCH"
+	ListSelectSpecialUse     = "SPECIAL-USE"
+
+	ListReturnSubscribed = "SUBSCRIBED"
+	ListReturnChildren   = "CHILDREN"
+	ListReturnSpecialUse = "SPECIAL-USE"
+)
+
+// ListOptions controls which mailboxes ListExtended returns and what
+// it says about them.
+type ListOptions struct {
+	// Selection restricts the mailboxes listed, e.g. to the
+	// subscribed ones.
+	Selection []string
+	// Return asks for attributes that plain LIST leaves out.
+	Return []string
+	// Status asks for the Status items of each mailbox, filled in
+	// as ResponseList.Status.  It requires LIST-STATUS (RFC 5819).
+	Status []string
+}
+
+// ListExtended is like List, but matches several patterns at once and
+// takes the selection and return options of LIST-EXTENDED (RFC 5258).
+// opts may be nil.  Servers that support SPECIAL-USE report mailbox
+// roles from plain List too; use ListReturnSpecialUse when they need
+// to be asked.
+func (imap *IMAP) ListExtended(reference string, patterns []string, opts *ListOptions) ([]*ResponseList, os.Error) {
+	hasListExtended, err := imap.HasCapability("LIST-EXTENDED")
+	if err != nil {
+		return nil, err
+	}
+	if !hasListExtended {
+		return nil, os.NewError("imap: server does not support LIST-EXTENDED")
+	}
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	returns := make([]string, len(opts.Return))
+	copy(returns, opts.Return)
+	if len(opts.Status) > 0 {
+		hasListStatus, err := imap.HasCapability("LIST-STATUS")
+		if err != nil {
+			return nil, err
+		}
+		if !hasListStatus {
+			return nil, os.NewError("imap: server does not support LIST-STATUS")
+		}
+		status := "STATUS (" + strings.Join(opts.Status, " ") + ")"
+		returns = append(returns, status)
+	}
+
+	command := "LIST "
+	if len(opts.Selection) > 0 {
+		command += "(" + strings.Join(opts.Selection, " ") + ") "
+	}
+	command += quote(reference) + " "
+	quoted := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		quoted[i] = quote(pattern)
+	}
+	command += "(" + strings.Join(quoted, " ") + ")"
+	if len(returns) > 0 {
+		command += " RETURN (" + strings.Join(returns, " ") + ")"
+	}
+	return imap.list("%s", command)
+}
+
+// Create creates a mailbox.
+func (imap *IMAP) Create(mailbox string) os.Error {
+	return imap.sendSimple("CREATE %s", quote(mailbox))
+}
+
+// Delete deletes a mailbox.
+func (imap *IMAP) Delete(mailbox string) os.Error {
+	return imap.sendSimple("DELETE %s", quote(mailbox))
+}
+
+// Rename renames a mailbox.
+func (imap *IMAP) Rename(mailbox string, newName string) os.Error {
+	return imap.sendSimple("RENAME %s %s", quote(mailbox), quote(newName))
+}
+
+// Subscribe adds a mailbox to the subscribed list returned by Lsub.
+func (imap *IMAP) Subscribe(mailbox string) os.Error {
+	return imap.sendSimple("SUBSCRIBE %s", quote(mailbox))
+}
+
+// Unsubscribe removes a mailbox from the subscribed list.
+func (imap *IMAP) Unsubscribe(mailbox string) os.Error {
+	return imap.sendSimple("UNSUBSCRIBE %s", quote(mailbox))
+}
+
+// Namespace returns the server's personal, other users' and shared
+// namespaces (RFC 2342), which tell where mailboxes live beyond the
+// root searched by a plain List.
+func (imap *IMAP) Namespace() (*ResponseNamespace, os.Error) {
+	resp, err := imap.SendSync("NAMESPACE")
+	if err != nil {
+		return nil, err
+	}
+
+	var namespace *ResponseNamespace
+	for _, extra := range resp.extra {
+		if ns, ok := extra.(*ResponseNamespace); ok && namespace == nil {
+			namespace = ns
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	if namespace == nil {
+		return nil, os.NewError("imap: no NAMESPACE response")
+	}
+	return namespace, nil
+}
+
+// ID identifies the client to the server and returns the server's
+// identification (RFC 2971).  Field names are like "name", "version"
+// and "vendor"; params may be nil to send nothing.
+func (imap *IMAP) ID(params map[string]string) (map[string]string, os.Error) {
+	args := "NIL"
+	if len(params) > 0 {
+		keys := make([]string, 0, len(params))
+		for key := range params {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fields := make([]string, 0, 2*len(keys))
+		for _, key := range keys {
+			fields = append(fields, quote(key), quote(params[key]))
+		}
+		args = "(" + strings.Join(fields, " ") + ")"
+	}
+
+	resp, err := imap.SendSync("ID %s", args)
+	if err != nil {
+		return nil, err
+	}
+
+	var id *ResponseID
+	for _, extra := range resp.extra {
+		if r, ok := extra.(*ResponseID); ok && id == nil {
+			id = r
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	if id == nil {
+		return nil, os.NewError("imap: no ID response")
+	}
+	return id.Params, nil
+}
+
+// Status data items, for use with Status.
+const (
+	StatusMessages    = "MESSAGES"
+	StatusRecent      = "RECENT"
+	StatusUIDNext     = "UIDNEXT"
+	StatusUIDValidity = "UIDVALIDITY"
+	StatusUnseen      = "UNSEEN"
+
+	// Requires CONDSTORE (RFC 7162).
+	StatusHighestModSeq = "HIGHESTMODSEQ"
+)
+
+// Status returns counts for a mailbox without selecting it.  items
+// lists the Status* values to request; if it is empty, all of them
+// are requested.
+func (imap *IMAP) Status(mailbox string, items []string) (*ResponseStatusData, os.Error) {
+	if len(items) == 0 {
+		items = []string{StatusMessages, StatusRecent, StatusUIDNext, StatusUIDValidity, StatusUnseen}
+	}
+	resp, err := imap.SendSync("STATUS %s (%s)", quote(mailbox), strings.Join(items, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	var status *ResponseStatusData
+	for _, extra := range resp.extra {
+		if s, ok := extra.(*ResponseStatusData); ok && status == nil {
+			status = s
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	if status == nil {
+		return nil, os.NewError("imap: no STATUS response")
+	}
+	return status, nil
+}
+
+// ResponseExamine contains the response to examining or selecting a
+// mailbox.
 type ResponseExamine struct {
 	Flags          []string
 	Exists         int
 	Recent         int
+	Unseen         int
 	PermanentFlags []string
-	UIDValidity    int
+	UIDValidity    uint32
 	UIDNext        int
+	ReadOnly       bool
+
+	// With CONDSTORE; zero if the mailbox has no mod-sequences.
+	HighestModSeq uint64
+
+	// With QRESYNC (see SelectQResync), the messages expunged and
+	// changed since the client's last sync.
+	Vanished SeqSet
+	Changed  []*ResponseFetch
 }
 
+// Examine opens a mailbox read-only.
 func (imap *IMAP) Examine(mailbox string) (*ResponseExamine, os.Error) {
+	return imap.selectMailbox("EXAMINE", mailbox, "")
+}
+
+// Select opens a mailbox read-write.  The server may still grant only
+// read-only access; check ReadOnly in the response.
+func (imap *IMAP) Select(mailbox string) (*ResponseExamine, os.Error) {
+	return imap.selectMailbox("SELECT", mailbox, "")
+}
+
+// SelectQResync is like Select, but also returns what changed in the
+// mailbox since a previous session, given the UIDVALIDITY and highest
+// mod-sequence seen then (RFC 7162 section 3.2.5).  knownUIDs, if not
+// empty, limits the report to those UIDs.  QRESYNC must first be
+// turned on with Enable.
+func (imap *IMAP) SelectQResync(mailbox string, uidValidity uint32, modseq uint64, knownUIDs string) (*ResponseExamine, os.Error) {
+	if !imap.Enabled("QRESYNC") {
+		return nil, os.NewError("imap: QRESYNC is not enabled")
+	}
+	params := fmt.Sprintf("%d %d", uidValidity, modseq)
+	if knownUIDs != "" {
+		params += " " + knownUIDs
+	}
+	return imap.selectMailbox("SELECT", mailbox, " (QRESYNC ("+params+"))")
+}
+
+func (imap *IMAP) selectMailbox(command string, mailbox string, params string) (*ResponseExamine, os.Error) {
 	/*
 	 Responses:  REQUIRED untagged responses: FLAGS, EXISTS, RECENT
 	 REQUIRED OK untagged responses:  UNSEEN,  PERMANENTFLAGS,
 	 UIDNEXT, UIDVALIDITY
 	*/
-	resp, err := imap.SendSync("EXAMINE %s", quote(mailbox))
+	resp, err := imap.SendSync("%s %s%s", command, quote(mailbox), params)
 	if err != nil {
 		return nil, err
 	}
 
-	r := &ResponseExamine{}
+	r := &ResponseExamine{ReadOnly: command == "EXAMINE"}
+	switch resp.code.(type) {
+	case *ResponseReadOnly:
+		r.ReadOnly = true
+	case *ResponseReadWrite:
+		r.ReadOnly = false
+	}
 
 	for _, extra := range resp.extra {
 		switch extra := extra.(type) {
@@ -181,7 +737,8 @@ func (imap *IMAP) Examine(mailbox string) (*ResponseExamine, os.Error) {
 			r.Exists = extra.Count
 		case (*ResponseRecent):
 			r.Recent = extra.Count
-		// XXX unseen
+		case (*ResponseUnseen):
+			r.Unseen = extra.Value
 		case (*ResponsePermanentFlags):
 			r.PermanentFlags = extra.Flags
 		case (*ResponseUIDNext):
@@ -190,6 +747,12 @@ func (imap *IMAP) Examine(mailbox string) (*ResponseExamine, os.Error) {
 		case (*ResponseUIDValidity):
 			value := extra.Value
 			r.UIDValidity = value
+		case (*ResponseHighestModSeq):
+			r.HighestModSeq = extra.Value
+		case (*ResponseVanished):
+			r.Vanished = append(r.Vanished, extra.UIDs...)
+		case (*ResponseFetch):
+			r.Changed = append(r.Changed, extra)
 		default:
 			imap.Unsolicited <- extra
 		}
@@ -207,8 +770,8 @@ func formatFetch(sequence string, fields []string) string {
 	return fmt.Sprintf("FETCH %s %s", sequence, fieldsStr)
 }
 
-func (imap *IMAP) Fetch(sequence string, fields []string) ([]*ResponseFetch, os.Error) {
-	resp, err := imap.SendSync("%s", formatFetch(sequence, fields))
+func (imap *IMAP) fetch(command string) ([]*ResponseFetch, os.Error) {
+	resp, err := imap.SendSync("%s", command)
 	if err != nil {
 		return nil, err
 	}
@@ -224,19 +787,68 @@ func (imap *IMAP) Fetch(sequence string, fields []string) ([]*ResponseFetch, os.
 	return lists, nil
 }
 
-func (imap *IMAP) FetchAsync(sequence string, fields []string) (chan interface{}, os.Error) {
+func (imap *IMAP) Fetch(sequence string, fields []string) ([]*ResponseFetch, os.Error) {
+	return imap.fetch(formatFetch(sequence, fields))
+}
+
+// UIDFetch is like Fetch, but identifies messages by UID.  The UID of
+// each message is always included in the response.
+func (imap *IMAP) UIDFetch(uids string, fields []string) ([]*ResponseFetch, os.Error) {
+	return imap.fetch("UID " + formatFetch(uids, fields))
+}
+
+// FetchChangedSince is like Fetch, but only returns messages whose
+// mod-sequence is greater than modseq (RFC 7162 section 3.1.4).
+func (imap *IMAP) FetchChangedSince(sequence string, fields []string, modseq uint64) ([]*ResponseFetch, os.Error) {
+	return imap.fetch(formatFetch(sequence, fields) + fmt.Sprintf(" (CHANGEDSINCE %d)", modseq))
+}
+
+// UIDFetchChangedSince is like FetchChangedSince, but identifies
+// messages by UID.  If QRESYNC is enabled, it also returns the UIDs
+// of messages expunged since modseq.
+func (imap *IMAP) UIDFetchChangedSince(uids string, fields []string, modseq uint64) ([]*ResponseFetch, SeqSet, os.Error) {
+	modifier := fmt.Sprintf(" (CHANGEDSINCE %d)", modseq)
+	if imap.Enabled("QRESYNC") {
+		modifier = fmt.Sprintf(" (CHANGEDSINCE %d VANISHED)", modseq)
+	}
+	resp, err := imap.SendSync("UID %s%s", formatFetch(uids, fields), modifier)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fetches := make([]*ResponseFetch, 0)
+	var vanished SeqSet
+	for _, extra := range resp.extra {
+		switch extra := extra.(type) {
+		case *ResponseFetch:
+			fetches = append(fetches, extra)
+		case *ResponseVanished:
+			vanished = append(vanished, extra.UIDs...)
+		default:
+			imap.Unsolicited <- extra
+		}
+	}
+	return fetches, vanished, nil
+}
+
+func (imap *IMAP) fetchAsync(command string) (chan interface{}, os.Error) {
 	ch := make(chan interface{})
-	err := imap.Send(ch, formatFetch(sequence, fields))
+	err := imap.Send(ch, "%s", command)
 	if err != nil {
 		return nil, err
 	}
 
 	// Stream all responses to this message into outChan, and everything
-	// else into unsolicited.
+	// else into unsolicited.  If the connection is lost, outChan is
+	// closed.
 	outChan := make(chan interface{})
 	go func() {
 		for {
-			r := <-ch
+			r, ok := <-ch
+			if !ok {
+				close(outChan)
+				return
+			}
 			switch r := r.(type) {
 			case *ResponseFetch:
 				outChan <- r
@@ -251,12 +863,488 @@ func (imap *IMAP) FetchAsync(sequence string, fields []string) (chan interface{}
 	return outChan, nil
 }
 
+func (imap *IMAP) FetchAsync(sequence string, fields []string) (chan interface{}, os.Error) {
+	return imap.fetchAsync(formatFetch(sequence, fields))
+}
+
+// UIDFetchAsync is like FetchAsync, but identifies messages by UID.
+func (imap *IMAP) UIDFetchAsync(uids string, fields []string) (chan interface{}, os.Error) {
+	return imap.fetchAsync("UID " + formatFetch(uids, fields))
+}
+
+// StoreMode selects how Store changes the flags of messages.
+type StoreMode int
+
+const (
+	StoreReplace StoreMode = iota // FLAGS: replace the flags
+	StoreAdd                      // +FLAGS: add to the flags
+	StoreRemove                   // -FLAGS: remove from the flags
+)
+
+func formatStore(sequence string, modifier string, mode StoreMode, silent bool, flags []Flag) string {
+	item := []string{"FLAGS", "+FLAGS", "-FLAGS"}[mode]
+	if silent {
+		item += ".SILENT"
+	}
+	strs := make([]string, len(flags))
+	for i, flag := range flags {
+		strs[i] = string(flag)
+	}
+	return fmt.Sprintf("STORE %s%s %s (%s)", sequence, modifier, item, strings.Join(strs, " "))
+}
+
+// store runs a STORE command, returning the updated flags and the set
+// of messages a conditional store skipped.
+func (imap *IMAP) store(command string) ([]*ResponseFetch, SeqSet, os.Error) {
+	resp, err := imap.SendSync("%s", command)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fetches := make([]*ResponseFetch, 0)
+	for _, extra := range resp.extra {
+		if fetch, ok := extra.(*ResponseFetch); ok {
+			fetches = append(fetches, fetch)
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	var modified SeqSet
+	if code, ok := resp.code.(*ResponseModified); ok {
+		modified = code.Set
+	}
+	return fetches, modified, nil
+}
+
+// Store changes the flags of the messages in sequence and returns
+// their updated flags.  If silent is set, the server does not send
+// the updated flags and the result is empty.
+func (imap *IMAP) Store(sequence string, mode StoreMode, silent bool, flags []Flag) ([]*ResponseFetch, os.Error) {
+	fetches, _, err := imap.store(formatStore(sequence, "", mode, silent, flags))
+	return fetches, err
+}
+
+// UIDStore is like Store, but identifies messages by UID.
+func (imap *IMAP) UIDStore(uids string, mode StoreMode, silent bool, flags []Flag) ([]*ResponseFetch, os.Error) {
+	fetches, _, err := imap.store("UID " + formatStore(uids, "", mode, silent, flags))
+	return fetches, err
+}
+
+// StoreUnchangedSince is like Store, but leaves alone messages whose
+// mod-sequence is greater than modseq (RFC 7162 section 3.1.3).  Those
+// messages are returned in the set of modified messages.
+func (imap *IMAP) StoreUnchangedSince(sequence string, modseq uint64, mode StoreMode, silent bool, flags []Flag) ([]*ResponseFetch, SeqSet, os.Error) {
+	modifier := fmt.Sprintf(" (UNCHANGEDSINCE %d)", modseq)
+	return imap.store(formatStore(sequence, modifier, mode, silent, flags))
+}
+
+// UIDStoreUnchangedSince is like StoreUnchangedSince, but identifies
+// messages by UID.
+func (imap *IMAP) UIDStoreUnchangedSince(uids string, modseq uint64, mode StoreMode, silent bool, flags []Flag) ([]*ResponseFetch, SeqSet, os.Error) {
+	modifier := fmt.Sprintf(" (UNCHANGEDSINCE %d)", modseq)
+	return imap.store("UID " + formatStore(uids, modifier, mode, silent, flags))
+}
+
+// copyUID runs a COPY or MOVE command and returns the UIDs the
+// server assigned, which may come in the status response or, for
+// MOVE, in an untagged one.
+func (imap *IMAP) copyUID(format string, args ...interface{}) (*ResponseCopyUID, os.Error) {
+	resp, err := imap.SendSync(format, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	copyUID, _ := resp.code.(*ResponseCopyUID)
+	for _, extra := range resp.extra {
+		if c, ok := extra.(*ResponseCopyUID); ok && copyUID == nil {
+			copyUID = c
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	return copyUID, nil
+}
+
+// Copy copies the messages in sequence to the end of mailbox.  If the
+// server supports UIDPLUS, the UIDs of the copies are returned;
+// otherwise the returned *ResponseCopyUID is nil.
+func (imap *IMAP) Copy(sequence string, mailbox string) (*ResponseCopyUID, os.Error) {
+	return imap.copyUID("COPY %s %s", sequence, quote(mailbox))
+}
+
+// UIDCopy is like Copy, but identifies messages by UID.
+func (imap *IMAP) UIDCopy(uids string, mailbox string) (*ResponseCopyUID, os.Error) {
+	return imap.copyUID("UID COPY %s %s", uids, quote(mailbox))
+}
+
+// Move moves the messages in sequence to the end of mailbox (RFC
+// 6851), returning their new UIDs like Copy does.  If the server does
+// not support MOVE, the messages are copied, marked deleted and
+// expunged by UID, which requires UIDPLUS.
+func (imap *IMAP) Move(sequence string, mailbox string) (*ResponseCopyUID, os.Error) {
+	hasMove, err := imap.HasCapability("MOVE")
+	if err != nil {
+		return nil, err
+	}
+	if hasMove {
+		return imap.copyUID("MOVE %s %s", sequence, quote(mailbox))
+	}
+
+	// Expunging by sequence number could remove other messages
+	// already marked deleted, so look up the UIDs and move those.
+	fetches, err := imap.Fetch(sequence, []string{"UID"})
+	if err != nil {
+		return nil, err
+	}
+	if len(fetches) == 0 {
+		return nil, nil
+	}
+	uids := make([]string, len(fetches))
+	for i, fetch := range fetches {
+		uids[i] = strconv.Itoa(int(fetch.UID))
+	}
+	return imap.moveFallback(strings.Join(uids, ","), mailbox)
+}
+
+// UIDMove is like Move, but identifies messages by UID.
+func (imap *IMAP) UIDMove(uids string, mailbox string) (*ResponseCopyUID, os.Error) {
+	hasMove, err := imap.HasCapability("MOVE")
+	if err != nil {
+		return nil, err
+	}
+	if hasMove {
+		return imap.copyUID("UID MOVE %s %s", uids, quote(mailbox))
+	}
+	return imap.moveFallback(uids, mailbox)
+}
+
+func (imap *IMAP) moveFallback(uids string, mailbox string) (*ResponseCopyUID, os.Error) {
+	hasUIDPlus, err := imap.HasCapability("UIDPLUS")
+	if err != nil {
+		return nil, err
+	}
+	if !hasUIDPlus {
+		return nil, os.NewError("imap: server supports neither MOVE nor UIDPLUS")
+	}
+
+	copyUID, err := imap.UIDCopy(uids, mailbox)
+	if err != nil {
+		return nil, err
+	}
+	fetches, err := imap.UIDStore(uids, StoreAdd, true, []Flag{FlagDeleted})
+	if err != nil {
+		return nil, err
+	}
+	for _, fetch := range fetches {
+		imap.Unsolicited <- fetch
+	}
+	msgs, vanished, err := imap.UIDExpunge(uids)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		imap.Unsolicited <- &ResponseExpunge{msg}
+	}
+	if len(vanished) > 0 {
+		imap.Unsolicited <- &ResponseVanished{false, vanished}
+	}
+	return copyUID, nil
+}
+
+func (imap *IMAP) expunge(command string) ([]int, SeqSet, os.Error) {
+	resp, err := imap.SendSync("%s", command)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msgs := make([]int, 0)
+	var vanished SeqSet
+	for _, extra := range resp.extra {
+		switch extra := extra.(type) {
+		case *ResponseExpunge:
+			msgs = append(msgs, extra.Msg)
+		case *ResponseVanished:
+			if extra.Earlier {
+				imap.Unsolicited <- extra
+			} else {
+				vanished = append(vanished, extra.UIDs...)
+			}
+		default:
+			imap.Unsolicited <- extra
+		}
+	}
+	return msgs, vanished, nil
+}
+
+// Expunge permanently removes all messages with the \Deleted flag
+// from the selected mailbox.  It returns the sequence numbers
+// reported as expunged, in the order the server sent them; note that
+// each expunge renumbers the messages after it.  Once QRESYNC is
+// enabled, the server reports the UIDs of the expunged messages
+// instead, which are returned as the SeqSet.
+func (imap *IMAP) Expunge() ([]int, SeqSet, os.Error) {
+	return imap.expunge("EXPUNGE")
+}
+
+// UIDExpunge is like Expunge, but only removes deleted messages whose
+// UIDs are in uids.  It requires the UIDPLUS extension (RFC 4315).
+func (imap *IMAP) UIDExpunge(uids string) ([]int, SeqSet, os.Error) {
+	return imap.expunge("UID EXPUNGE " + uids)
+}
+
+// Append adds the size-byte message read from msg to the end of
+// mailbox.  The message is streamed to the server, not held in memory.
+// If size is negative, it is taken from msg's Len method, as offered
+// by bytes.Buffer and strings.Reader.  flags and date are optional and
+// may be nil.  If the server supports UIDPLUS, the UID of the new
+// message is returned; otherwise the returned *ResponseAppendUID is
+// nil.
+//
+// The server expects exactly size bytes, so if msg ends early or fails
+// to read, the connection is closed.
+func (imap *IMAP) Append(mailbox string, flags []Flag, date *time.Time, msg io.Reader, size int64) (*ResponseAppendUID, os.Error) {
+	if size < 0 {
+		lener, ok := msg.(interface {
+			Len() int
+		})
+		if !ok {
+			return nil, os.NewError("imap: message size unknown")
+		}
+		size = int64(lener.Len())
+	}
+
+	var opts string
+	if len(flags) > 0 {
+		strs := make([]string, len(flags))
+		for i, flag := range flags {
+			strs[i] = string(flag)
+		}
+		opts += " (" + strings.Join(strs, " ") + ")"
+	}
+	if date != nil {
+		opts += " " + quote(date.Format("02-Jan-2006 15:04:05 -0700"))
+	}
+
+	ch := make(chan interface{}, 1)
+	err := imap.Send(ch, "APPEND %s%s {%d}", quote(mailbox), opts, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server must ask for the literal before we send it, and may
+	// refuse the command instead.
+	err = imap.waitContinuation(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := io.Copy(imap.conn, io.LimitReader(msg, size))
+	if err == nil && n < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// The server is still waiting for the rest of the literal.
+		imap.Close()
+		return nil, err
+	}
+	_, err = imap.conn.Write([]byte("\r\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := imap.readSync(ch)
+	if err != nil {
+		return nil, err
+	}
+	for _, extra := range resp.extra {
+		imap.Unsolicited <- extra
+	}
+	appendUID, _ := resp.code.(*ResponseAppendUID)
+	return appendUID, nil
+}
+
+// Idle starts an IDLE command (RFC 2177), during which the server
+// sends mailbox updates like *ResponseExists, *ResponseExpunge and
+// *ResponseFetch as they happen.  The updates are streamed to the
+// returned channel, followed by the *ResponseStatus that ends the
+// command.  If the connection is lost, the channel is closed.
+//
+// The idle ends when stop receives a value or is closed, or when
+// timeout nanoseconds have passed if timeout is positive.  Servers may
+// drop a client that idles for 30 minutes, so a timeout of a few
+// minutes less is a good idea.  No other command may be sent until the
+// final status has arrived.
+func (imap *IMAP) Idle(stop chan bool, timeout int64) (chan interface{}, os.Error) {
+	hasIdle, err := imap.HasCapability("IDLE")
+	if err != nil {
+		return nil, err
+	}
+	if !hasIdle {
+		return nil, os.NewError("imap: server does not support IDLE")
+	}
+
+	ch := make(chan interface{}, 1)
+	err = imap.Send(ch, "IDLE")
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for the server to agree to idle.  Anything that arrives
+	// before then is passed on as an update.
+	early := make([]interface{}, 0)
+L:
+	for {
+		r, ok := <-ch
+		if !ok {
+			return nil, ErrClosed
+		}
+		switch r := r.(type) {
+		case *ResponseContinuation:
+			break L
+		case *ResponseStatus:
+			return nil, &IMAPError{r.status, r.code, r.text}
+		default:
+			early = append(early, r)
+		}
+	}
+
+	// idleDone is closed when the idle ends, so that the timer
+	// goroutine doesn't outlive it.  The goroutine keeps its own copy
+	// of timedOut, which done clears below.
+	timedOut := make(chan bool, 1)
+	idleDone := make(chan bool)
+	if timeout > 0 {
+		timer := timedOut
+		go func() {
+			select {
+			case <-time.After(timeout):
+				select {
+				case timer <- true:
+				case <-idleDone:
+				}
+			case <-idleDone:
+			}
+		}()
+	}
+
+	outChan := make(chan interface{}, len(early))
+	for _, r := range early {
+		outChan <- r
+	}
+	go func() {
+		defer close(idleDone)
+		// Once DONE is sent, stop and timedOut are set to nil so that
+		// a closed stop doesn't keep firing.
+		done := func() {
+			stop, timedOut = nil, nil
+			imap.conn.Write([]byte("DONE\r\n"))
+		}
+		for {
+			select {
+			case r, ok := <-ch:
+				if !ok {
+					close(outChan)
+					return
+				}
+				// Keep watching stop while the caller is busy, so
+				// that stopping never blocks.
+				for sent := false; !sent; {
+					select {
+					case outChan <- r:
+						sent = true
+					case <-stop:
+						done()
+					case <-timedOut:
+						done()
+					}
+				}
+				if _, ok := r.(*ResponseStatus); ok {
+					return
+				}
+			case <-stop:
+				done()
+			case <-timedOut:
+				done()
+			}
+		}
+	}()
+	return outChan, nil
+}
+
+// Logout ends the session and closes the connection.
+func (imap *IMAP) Logout() os.Error {
+	resp, err := imap.SendSync("LOGOUT")
+	// The server may hang up as soon as it has said BYE.
+	if err != nil && err != ErrClosed {
+		imap.Close()
+		return err
+	}
+	if resp != nil {
+		for _, extra := range resp.extra {
+			if _, ok := extra.(*ResponseBye); !ok {
+				imap.Unsolicited <- extra
+			}
+		}
+	}
+	return imap.Close()
+}
+
+// Close closes the connection without logging out.  A command in
+// progress and any later ones fail with ErrClosed.
+func (imap *IMAP) Close() os.Error {
+	imap.pendingLock.Lock()
+	imap.closed = true
+	imap.pendingLock.Unlock()
+
+	return imap.conn.Close()
+}
+
+// shutdown is called when the read loop exits.  It fails the pending
+// command, if any, and any later ones.
+func (imap *IMAP) shutdown() {
+	imap.pendingLock.Lock()
+	imap.closed = true
+	if imap.pendingChan != nil {
+		close(imap.pendingChan)
+		imap.pendingChan = nil
+	}
+	imap.pendingLock.Unlock()
+}
+
 // Repeatedly reads messages off the connection and dispatches them.
+// It returns when reading fails; the error is nil if the server had
+// said BYE first.
 func (imap *IMAP) readLoop() os.Error {
 	var msgChan chan interface{}
+	bye := false
 	for {
 		tag, r, err := imap.r.readResponse()
-		check(err)
+		if err != nil {
+			if bye {
+				return nil
+			}
+			return err
+		}
+		switch r := r.(type) {
+		case *ResponseBye:
+			bye = true
+		case *ResponseCapabilities:
+			imap.setCapabilities(r)
+		case *ResponseEnabled:
+			// The parser must know before the next response is read.
+			imap.r.enable(r.Capabilities)
+			enabled := make(map[string]bool)
+			for cap := range imap.r.enabled {
+				enabled[cap] = true
+			}
+			imap.capsLock.Lock()
+			imap.enabled = enabled
+			imap.capsLock.Unlock()
+		case *ResponseStatus:
+			if caps, ok := r.code.(*ResponseCapabilities); ok {
+				imap.setCapabilities(caps)
+			}
+		}
 
 		if msgChan == nil {
 			imap.pendingLock.Lock()
@@ -264,7 +1352,7 @@ func (imap *IMAP) readLoop() os.Error {
 			imap.pendingLock.Unlock()
 		}
 
-		if tag == untagged {
+		if tag == untagged || tag == continuation {
 			if msgChan != nil {
 				msgChan <- r
 			} else {
@@ -275,11 +1363,25 @@ func (imap *IMAP) readLoop() os.Error {
 
 			imap.pendingLock.Lock()
 			if imap.pendingTag != tag {
-				return fmt.Errorf("expected response tag %s, got %s", imap.pendingTag, tag)
+				imap.pendingLock.Unlock()
+				return fmt.Errorf("expected response tag a%d, got a%d", imap.pendingTag, tag)
 			}
 			imap.pendingChan = nil
+			tlsConfig := imap.pendingTLS
+			imap.pendingTLS = nil
 			imap.pendingLock.Unlock()
 
+			// The TLS handshake must happen here, before anything
+			// else is read off the plaintext connection.
+			if tlsConfig != nil && resp.status == OK {
+				err := imap.upgradeTLS(tlsConfig)
+				if err != nil {
+					imap.conn.Close()
+					msgChan <- err
+					return err
+				}
+			}
+
 			msgChan <- resp
 			msgChan = nil
 		}
@@ -27,13 +27,19 @@ func recoverError(err *os.Error) {
 type sexp interface{}
 // One of:
 //   string
+//   []byte (a literal)
 //   []sexp
 //   nil
 func nilOrString(s sexp) *string {
-	if s == nil {
+	var str string
+	switch s := s.(type) {
+	case nil:
 		return nil
+	case []byte:
+		str = string(s)
+	default:
+		str = s.(string)
 	}
-	str := s.(string)
 	return &str
 }
 
@@ -42,7 +48,7 @@ type parser struct {
 }
 
 func newParser(r io.Reader) *parser {
-	return &parser{bufio.NewReader(r)}
+	return &parser{Reader: bufio.NewReader(r)}
 }
 
 func (p *parser) expect(text string) os.Error {
@@ -118,7 +124,7 @@ func (p *parser) readAtom() (outStr string, outErr os.Error) {
 
 		switch c {
 		case '(', ')', '{', ' ',
-			// XXX: CTL
+			'\r', '\n', // XXX: other CTL
 			'%', '*', // list-wildcards
 			'"': // quoted-specials
 			// XXX: note that I dropped '\' from the quoted-specials,
@@ -154,6 +160,7 @@ func (p *parser) readQuoted() (outStr string, outErr os.Error) {
 				return "", fmt.Errorf("backslash-escaped %c", c)
 			}
 		case '"':
+			// The bytes are kept as sent, valid UTF-8 or not.
 			return quoted.String(), nil
 		}
 		quoted.WriteByte(c)
@@ -162,6 +169,28 @@ func (p *parser) readQuoted() (outStr string, outErr os.Error) {
 	panic("not reached")
 }
 
+func (p *parser) readAstring() (outStr string, outErr os.Error) {
+	/*
+		astring         = 1*ASTRING-CHAR / string
+		string          = quoted / literal
+	*/
+	defer recoverError(&outErr)
+
+	c, err := p.ReadByte()
+	check(err)
+	check(p.UnreadByte())
+
+	switch c {
+	case '"':
+		return p.readQuoted()
+	case '{':
+		literal, err := p.readLiteral()
+		check(err)
+		return string(literal), nil
+	}
+	return p.readAtom()
+}
+
 func (p *parser) readLiteral() (literal []byte, outErr os.Error) {
 	/*
 		literal         = "{" number "}" CRLF *CHAR8
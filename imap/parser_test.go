@@ -49,6 +49,46 @@ func TestParseString(t *testing.T) {
 	}.Run(t)
 }
 
+func TestParseAstring(t *testing.T) {
+	tests := []parseTest{
+		{
+			input:    "\"Sent Items\"",
+			code:     func(p *parser) (interface{}, os.Error) { return p.readAstring() },
+			expected: "Sent Items",
+		},
+		{
+			input:    "{5}\r\nDraft",
+			code:     func(p *parser) (interface{}, os.Error) { return p.readAstring() },
+			expected: "Draft",
+		},
+	}
+
+	for _, test := range tests {
+		test.Run(t)
+	}
+}
+
+func TestParse8Bit(t *testing.T) {
+	// Many servers send raw 8-bit text even without UTF8=ACCEPT.
+	parseTest{
+		input: "\"Gr\xfc\xdfe\"",
+		code: func(p *parser) (interface{}, os.Error) {
+			return p.readQuoted()
+		},
+		expected: "Gr\xfc\xdfe",
+	}.Run(t)
+}
+
+func TestParseUTF8(t *testing.T) {
+	parseTest{
+		input: "\"Gr\xc3\xbc\xc3\x9fe\"",
+		code: func(p *parser) (interface{}, os.Error) {
+			return p.readQuoted()
+		},
+		expected: "Gr\xc3\xbc\xc3\x9fe",
+	}.Run(t)
+}
+
 func TestParseLiteral(t *testing.T) {
 	tests := []parseTest{
 		{
@@ -1,8 +1,10 @@
 package imap
 
 import (
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"fmt"
 )
 
@@ -39,9 +41,11 @@ func (r *ResponseStatus) String() string {
 }
 
 // IMAPError is an error returned for IMAP-level errors, such
-// as "unknown mailbox".
+// as "unknown mailbox".  Code holds the response code, if any,
+// like *ResponseTryCreate.
 type IMAPError struct {
 	Status Status
+	Code   interface{}
 	Text   string
 }
 
@@ -49,6 +53,17 @@ func (e *IMAPError) String() string {
 	return fmt.Sprintf("imap: %s %s", e.Status, e.Text)
 }
 
+// IsTryCreate reports whether err is a failure because the target
+// mailbox does not exist, so that the command may succeed if it is
+// created first.
+func IsTryCreate(err os.Error) bool {
+	if e, ok := err.(*IMAPError); ok {
+		_, ok = e.Code.(*ResponseTryCreate)
+		return ok
+	}
+	return false
+}
+
 const (
 	WildcardAny          = "%"
 	WildcardAnyRecursive = "*"
@@ -58,8 +73,31 @@ type tag int
 
 const untagged = tag(-1)
 
+// continuation is the "tag" of a continuation request ("+ ...").
+const continuation = tag(-2)
+
 type reader struct {
 	*parser
+
+	// Extensions turned on with ENABLE, which change what the server
+	// may send.
+	enabled map[string]bool
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{newParser(r), make(map[string]bool)}
+}
+
+// enable records extensions the server reported as enabled.
+func (r *reader) enable(caps []string) {
+	for _, cap := range caps {
+		cap = strings.ToUpper(cap)
+		r.enabled[cap] = true
+		if cap == "QRESYNC" {
+			// QRESYNC implies CONDSTORE (RFC 7162 section 3.2.3).
+			r.enabled["CONDSTORE"] = true
+		}
+	}
 }
 
 // Read a full response (e.g. "* OK foobar\r\n").
@@ -75,6 +113,12 @@ func (r *reader) readResponse() (tag, interface{}, os.Error) {
 			return untagged, nil, err
 		}
 		return tag, resp, nil
+	} else if tag == continuation {
+		text, err := r.readToEOL()
+		if err != nil {
+			return untagged, nil, err
+		}
+		return tag, &ResponseContinuation{text}, nil
 	} else {
 		resp, err := r.readStatus("")
 		if err != nil {
@@ -87,7 +131,7 @@ func (r *reader) readResponse() (tag, interface{}, os.Error) {
 }
 
 // Read the tag, the first part of the response.
-// Expects either "*" or "a123".
+// Expects either "*", "+" or "a123".
 func (r *reader) readTag() (tag, os.Error) {
 	str, err := r.readToken()
 	if err != nil {
@@ -100,6 +144,8 @@ func (r *reader) readTag() (tag, os.Error) {
 	switch str[0] {
 	case '*':
 		return untagged, nil
+	case '+':
+		return continuation, nil
 	case 'a':
 		tagnum, err := strconv.Atoi(str[1:])
 		if err != nil {
@@ -120,7 +166,7 @@ type ResponsePermanentFlags struct {
 // ResponseUIDValidity contains the unique identifier validity value.
 // See RFC 3501 section 2.3.1.1.
 type ResponseUIDValidity struct {
-	Value int
+	Value uint32
 }
 
 // ResponseUIDNext contains the next message uid.
@@ -129,6 +175,75 @@ type ResponseUIDNext struct {
 	Value int
 }
 
+// ResponseUnseen contains the sequence number of the first unseen
+// message in a mailbox.
+type ResponseUnseen struct {
+	Value int
+}
+
+// ResponseReadOnly indicates that the selected mailbox may not be
+// modified.
+type ResponseReadOnly struct{}
+
+// ResponseReadWrite indicates that the selected mailbox may be
+// modified.
+type ResponseReadWrite struct{}
+
+// ResponseAppendUID contains the UIDs assigned to appended messages,
+// in the destination mailbox with the given UIDVALIDITY.  See RFC 4315
+// section 3.
+type ResponseAppendUID struct {
+	UIDValidity uint32
+	UIDs        SeqSet
+}
+
+// ResponseCopyUID contains the UIDs assigned to copied or moved
+// messages.  The nth UID in Source became the nth UID in Dest, in the
+// destination mailbox with the given UIDVALIDITY.  See RFC 4315
+// section 3.
+type ResponseCopyUID struct {
+	UIDValidity  uint32
+	Source, Dest SeqSet
+}
+
+// readUIDValidity reads a UIDVALIDITY value, which may not fit in an
+// int, from a UIDVALIDITY, APPENDUID or COPYUID code.
+func (r *reader) readUIDValidity() uint32 {
+	token, err := r.readToken()
+	check(err)
+	validity, err := strconv.Atoui64(token)
+	check(err)
+	return uint32(validity)
+}
+
+func (r *reader) readSeqSet() SeqSet {
+	token, err := r.readToken()
+	check(err)
+	set, err := ParseSeqSet(token)
+	check(err)
+	return set
+}
+
+// ResponseTryCreate indicates that a command failed because the target
+// mailbox does not exist and may be created.
+type ResponseTryCreate struct{}
+
+// ResponseHighestModSeq contains the highest mod-sequence of all
+// messages in a mailbox.  See RFC 7162 section 3.1.2.1.
+type ResponseHighestModSeq struct {
+	Value uint64
+}
+
+// ResponseNoModSeq indicates that a mailbox doesn't support
+// mod-sequences.
+type ResponseNoModSeq struct{}
+
+// ResponseModified contains the messages that a conditional STORE
+// left alone because they changed since the given mod-sequence.
+type ResponseModified struct {
+	Set SeqSet
+}
+
 // Read a status response, one starting with OK/NO/BAD.
 func (r *reader) readStatus(statusStr string) (resp *ResponseStatus, outErr os.Error) {
 	defer func() {
@@ -175,15 +290,61 @@ func (r *reader) readStatus(statusStr string) (resp *ResponseStatus, outErr os.E
 			code = &ResponsePermanentFlags{flags}
 			check(r.expect("]"))
 		case "UIDVALIDITY":
-			num, err := r.readNumber()
-			check(err)
-			code = &ResponseUIDValidity{num}
+			code = &ResponseUIDValidity{r.readUIDValidity()}
 			check(r.expect("]"))
 		case "UIDNEXT":
 			num, err := r.readNumber()
 			check(err)
 			code = &ResponseUIDNext{num}
 			check(r.expect("]"))
+		case "UNSEEN":
+			num, err := r.readNumber()
+			check(err)
+			code = &ResponseUnseen{num}
+			check(r.expect("]"))
+		case "APPENDUID":
+			validity := r.readUIDValidity()
+			code = &ResponseAppendUID{validity, r.readSeqSet()}
+			check(r.expect("]"))
+		case "COPYUID":
+			validity := r.readUIDValidity()
+			source := r.readSeqSet()
+			code = &ResponseCopyUID{validity, source, r.readSeqSet()}
+			check(r.expect("]"))
+		case "READ-ONLY":
+			code = &ResponseReadOnly{}
+			check(r.expect("]"))
+		case "READ-WRITE":
+			code = &ResponseReadWrite{}
+			check(r.expect("]"))
+		case "CAPABILITY":
+			caps := make([]string, 0)
+			for {
+				cap, err := r.readToken()
+				check(err)
+				if len(cap) == 0 {
+					break
+				}
+				caps = append(caps, cap)
+			}
+			code = &ResponseCapabilities{caps}
+			check(r.expect("]"))
+		case "HIGHESTMODSEQ":
+			token, err := r.readToken()
+			check(err)
+			modseq, err := strconv.Atoui64(token)
+			check(err)
+			code = &ResponseHighestModSeq{modseq}
+			check(r.expect("]"))
+		case "NOMODSEQ":
+			code = &ResponseNoModSeq{}
+			check(r.expect("]"))
+		case "MODIFIED":
+			code = &ResponseModified{r.readSeqSet()}
+			check(r.expect("]"))
+		case "TRYCREATE":
+			code = &ResponseTryCreate{}
+			check(r.expect("]"))
 		default:
 			text, err := r.ReadString(']')
 			check(err)
@@ -215,12 +376,29 @@ func (r *reader) readStatus(statusStr string) (resp *ResponseStatus, outErr os.E
 	return &ResponseStatus{status, code, rest, nil}, nil
 }
 
+// ResponseContinuation contains the text of a continuation request,
+// sent by the server when it is ready for more data from the client.
+type ResponseContinuation struct {
+	Text string
+}
+
 // ResponseCapabilities contains the server capability list from a
 // CAPABILITIY message.
 type ResponseCapabilities struct {
 	Capabilities []string
 }
 
+// Has reports whether name is one of the capabilities.
+func (r *ResponseCapabilities) Has(name string) bool {
+	name = strings.ToUpper(name)
+	for _, cap := range r.Capabilities {
+		if strings.ToUpper(cap) == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *reader) readCAPABILITY() *ResponseCapabilities {
 	caps := make([]string, 0)
 	for {
@@ -235,54 +413,135 @@ func (r *reader) readCAPABILITY() *ResponseCapabilities {
 	return &ResponseCapabilities{caps}
 }
 
-// ResponseList contains the list metadata from a LIST message.
+// MailboxRole is a SPECIAL-USE mailbox attribute, marking a mailbox
+// that holds a particular kind of message.  See RFC 6154.
+type MailboxRole string
+
+const (
+	RoleAll     MailboxRole = "\\All"
+	RoleArchive MailboxRole = "\\Archive"
+	RoleDrafts  MailboxRole = "\\Drafts"
+	RoleFlagged MailboxRole = "\\Flagged"
+	RoleJunk    MailboxRole = "\\Junk"
+	RoleSent    MailboxRole = "\\Sent"
+	RoleTrash   MailboxRole = "\\Trash"
+)
+
+var mailboxRoles = []MailboxRole{
+	RoleAll, RoleArchive, RoleDrafts, RoleFlagged, RoleJunk, RoleSent, RoleTrash,
+}
+
+// ResponseList contains the list metadata from a LIST or LSUB
+// message.  Attributes holds every attribute the server sent, including
+// ones this package doesn't know; the common ones are also decoded into
+// the *bool fields, which are nil if the server didn't say.  Delim is
+// empty if the server has no hierarchy.  ChildInfo holds the selection
+// criteria matched by children of the mailbox, for an extended LIST
+// with ListSelectRecursiveMatch.  Status is set if ListOptions.Status
+// asked for it and the mailbox is selectable.
 type ResponseList struct {
 	Inferiors,
 	Selectable,
 	Marked,
 	Children *bool
-	Delim string
-	Name  string
+	Attributes []string
+	Delim      string
+	Name       string
+	ChildInfo  []string
+	Status     *ResponseStatusData
+}
+
+// Has returns whether the mailbox has the attribute attr, like
+// "\\Subscribed".  Attributes are compared without regard to case.
+func (list *ResponseList) Has(attr string) bool {
+	attr = strings.ToLower(attr)
+	for _, a := range list.Attributes {
+		if strings.ToLower(a) == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// Role returns the SPECIAL-USE role of the mailbox, or "" if it has
+// none.
+func (list *ResponseList) Role() MailboxRole {
+	for _, role := range mailboxRoles {
+		if list.Has(string(role)) {
+			return role
+		}
+	}
+	return ""
 }
 
 func (r *reader) readLIST() *ResponseList {
-	// "(" [mbx-list-flags] ")" SP (DQUOTE QUOTED-CHAR DQUOTE / nil) SP mailbox
+	/*
+		mailbox-list = "(" [mbx-list-flags] ")" SP
+		               (DQUOTE QUOTED-CHAR DQUOTE / nil) SP mailbox
+		               [SP mbox-list-extended]
+	*/
 	flags, err := r.readParenStringList()
 	check(err)
-	r.expect(" ")
+	check(r.expect(" "))
 
-	delim, err := r.readQuoted()
+	list := &ResponseList{Attributes: flags}
+	c, err := r.ReadByte()
+	check(err)
+	check(r.UnreadByte())
+	if c == '"' {
+		list.Delim, err = r.readQuoted()
+		check(err)
+	} else {
+		check(r.expect("NIL"))
+	}
+	check(r.expect(" "))
+
+	list.Name, err = r.readAstring()
 	check(err)
-	r.expect(" ")
 
-	name, err := r.readQuoted()
+	c, err = r.ReadByte()
 	check(err)
+	check(r.UnreadByte())
+	if c == ' ' {
+		check(r.expect(" "))
+		// "(" mbox-list-extended-item *(SP mbox-list-extended-item) ")"
+		extended, err := r.readSexp()
+		check(err)
+		for i := 0; i+1 < len(extended); i += 2 {
+			if tag, ok := extended[i].(string); ok && strings.ToUpper(tag) == "CHILDINFO" {
+				if info, ok := extended[i+1].([]sexp); ok {
+					for _, s := range info {
+						if str, ok := s.(string); ok {
+							list.ChildInfo = append(list.ChildInfo, str)
+						}
+					}
+				}
+			}
+		}
+	}
 
 	check(r.expectEOL())
 
-	list := &ResponseList{Delim: string(delim), Name: string(name)}
 	for _, flag := range flags {
-		switch flag {
-		case "\\Noinferiors":
+		switch strings.ToLower(flag) {
+		case "\\noinferiors":
 			b := false
 			list.Inferiors = &b
-		case "\\Noselect":
+		case "\\noselect", "\\nonexistent":
 			b := false
 			list.Selectable = &b
-		case "\\Marked":
+		case "\\marked":
 			b := true
 			list.Marked = &b
-		case "\\Unmarked":
+		case "\\unmarked":
 			b := false
 			list.Marked = &b
-		case "\\HasChildren":
+		case "\\haschildren":
 			b := true
 			list.Children = &b
-		case "\\HasNoChildren":
+		case "\\hasnochildren":
 			b := false
 			list.Children = &b
-		default:
-			panic(fmt.Sprintf("unknown list flag %q", flag))
 		}
 	}
 	return list
@@ -300,6 +559,29 @@ func (r *reader) readFLAGS() *ResponseFlags {
 	return &ResponseFlags{flags}
 }
 
+// Flag is a message flag, either one of the system flags below or a
+// keyword.
+type Flag string
+
+// System flags, as defined in RFC 3501 section 2.3.2.
+const (
+	FlagSeen     Flag = "\\Seen"
+	FlagAnswered Flag = "\\Answered"
+	FlagFlagged  Flag = "\\Flagged"
+	FlagDeleted  Flag = "\\Deleted"
+	FlagDraft    Flag = "\\Draft"
+	FlagRecent   Flag = "\\Recent"
+)
+
+func flagsFromSexp(s sexp) []Flag {
+	items := s.([]sexp)
+	flags := make([]Flag, len(items))
+	for i, item := range items {
+		flags[i] = Flag(item.(string))
+	}
+	return flags
+}
+
 // ResponseFetchEnvelope contains the broken-down message metadata
 // retrieved when fetching the ENVELOPE data of a message.
 type ResponseFetchEnvelope struct {
@@ -310,7 +592,9 @@ type ResponseFetchEnvelope struct {
 // ResponseFetch contains the message data from a FETCH message.
 type ResponseFetch struct {
 	Msg                  int
-	Flags                sexp
+	UID                  uint32
+	ModSeq               uint64
+	Flags                []Flag
 	Envelope             ResponseFetchEnvelope
 	InternalDate         string
 	Size                 int
@@ -344,7 +628,16 @@ func (r *reader) readFETCH(num int) *ResponseFetch {
 			fetch.Envelope.inReplyTo = nilOrString(env[8])
 			fetch.Envelope.messageId = nilOrString(env[9])
 		case "FLAGS":
-			fetch.Flags = s[i+1]
+			fetch.Flags = flagsFromSexp(s[i+1])
+		case "UID":
+			uid, err := strconv.Atoui64(s[i+1].(string))
+			check(err)
+			fetch.UID = uint32(uid)
+		case "MODSEQ":
+			// "MODSEQ" SP "(" permsg-modsequence ")"
+			modseq := s[i+1].([]sexp)
+			fetch.ModSeq, err = strconv.Atoui64(modseq[0].(string))
+			check(err)
 		case "INTERNALDATE":
 			fetch.InternalDate = s[i+1].(string)
 		case "RFC822":
@@ -362,6 +655,472 @@ func (r *reader) readFETCH(num int) *ResponseFetch {
 	return fetch
 }
 
+// ResponseSearch contains the message numbers or UIDs from a SEARCH
+// message.  With CONDSTORE, ModSeq is the highest mod-sequence of the
+// messages found.
+type ResponseSearch struct {
+	IDs    []uint32
+	ModSeq uint64
+}
+
+func (r *reader) readSEARCH() *ResponseSearch {
+	search := &ResponseSearch{IDs: make([]uint32, 0)}
+	for {
+		c, err := r.ReadByte()
+		check(err)
+		check(r.UnreadByte())
+		if c == '(' {
+			// "(" "MODSEQ" SP mod-sequence-value ")"
+			s, err := r.readSexp()
+			check(err)
+			if len(s) != 2 || s[0] != "MODSEQ" {
+				panic(fmt.Errorf("unexpected search modifier %v", s))
+			}
+			search.ModSeq, err = strconv.Atoui64(s[1].(string))
+			check(err)
+			break
+		}
+
+		str, err := r.readToken()
+		check(err)
+		if len(str) == 0 {
+			break
+		}
+		id, err := strconv.Atoui64(str)
+		check(err)
+		search.IDs = append(search.IDs, uint32(id))
+	}
+	check(r.expectEOL())
+	return search
+}
+
+// ResponseESearch contains the results from an ESEARCH message (RFC
+// 4731).  Only the items that were requested are filled in; Min and
+// Max are 0 if not returned, and All is nil.  Tag is the tag of the
+// command the results belong to.
+type ResponseESearch struct {
+	Tag      string
+	UID      bool
+	Min, Max uint32
+	Count    int
+	All      SeqSet
+	ModSeq   uint64
+}
+
+func (r *reader) readESEARCH() *ResponseESearch {
+	search := &ResponseESearch{}
+	for {
+		c, err := r.ReadByte()
+		check(err)
+		check(r.UnreadByte())
+		if c == '(' {
+			// "(" "TAG" SP tag-string ")"
+			s, err := r.readSexp()
+			check(err)
+			if len(s) != 2 || s[0] != "TAG" {
+				panic(fmt.Errorf("unexpected search correlator %v", s))
+			}
+			search.Tag = s[1].(string)
+			r.skipSpace()
+			continue
+		}
+
+		name, err := r.readToken()
+		check(err)
+		if len(name) == 0 {
+			break
+		}
+		if name == "UID" {
+			search.UID = true
+			continue
+		}
+
+		// Return data from extensions we don't know, like PARTIAL,
+		// may have a parenthesized or quoted value; skip it.
+		c, err = r.ReadByte()
+		check(err)
+		check(r.UnreadByte())
+		if c == '(' || c == '"' {
+			if c == '(' {
+				_, err = r.readSexp()
+			} else {
+				_, err = r.readQuoted()
+			}
+			check(err)
+			r.skipSpace()
+			continue
+		}
+
+		value, err := r.readToken()
+		check(err)
+		switch name {
+		case "MIN", "MAX":
+			n, err := strconv.Atoui64(value)
+			check(err)
+			if name == "MIN" {
+				search.Min = uint32(n)
+			} else {
+				search.Max = uint32(n)
+			}
+		case "COUNT":
+			search.Count, err = strconv.Atoi(value)
+			check(err)
+		case "ALL":
+			search.All, err = ParseSeqSet(value)
+			check(err)
+		case "MODSEQ":
+			search.ModSeq, err = strconv.Atoui64(value)
+			check(err)
+		}
+	}
+	check(r.expectEOL())
+	return search
+}
+
+// skipSpace reads the space after an item, if there is one.
+func (r *reader) skipSpace() {
+	c, err := r.ReadByte()
+	check(err)
+	if c != ' ' {
+		check(r.UnreadByte())
+	}
+}
+
+// ResponseSort contains the message IDs from a SORT message, in sorted
+// order.  See RFC 5256.
+type ResponseSort struct {
+	IDs    []uint32
+	ModSeq uint64
+}
+
+func (r *reader) readSORT() *ResponseSort {
+	// SORT results have the same syntax as SEARCH results.
+	search := r.readSEARCH()
+	return &ResponseSort{search.IDs, search.ModSeq}
+}
+
+// ThreadNode is a message in a THREAD response, along with its
+// replies.  An ID of 0 stands for a parent message that is missing
+// from the mailbox, and only has Children.
+type ThreadNode struct {
+	ID       uint32
+	Children []*ThreadNode
+}
+
+// ResponseThread contains the threads from a THREAD message.  See RFC
+// 5256.
+type ResponseThread struct {
+	Threads []*ThreadNode
+}
+
+// threadFromSexp converts one parenthesized thread, in which each ID is
+// the parent of the next and nested threads are siblings hanging off
+// the last ID, into a tree.
+func threadFromSexp(s []sexp) *ThreadNode {
+	var root, node *ThreadNode
+	for _, exp := range s {
+		switch exp := exp.(type) {
+		case string:
+			id, err := strconv.Atoui64(exp)
+			check(err)
+			child := &ThreadNode{uint32(id), nil}
+			if node == nil {
+				root = child
+			} else {
+				node.Children = append(node.Children, child)
+			}
+			node = child
+		case []sexp:
+			if node == nil {
+				node = &ThreadNode{}
+				root = node
+			}
+			node.Children = append(node.Children, threadFromSexp(exp))
+		default:
+			panic(fmt.Errorf("unexpected thread element %v", exp))
+		}
+	}
+	if root == nil {
+		panic(os.NewError("empty thread"))
+	}
+	return root
+}
+
+func (r *reader) readTHREAD() *ResponseThread {
+	thread := &ResponseThread{make([]*ThreadNode, 0)}
+	for {
+		c, err := r.ReadByte()
+		check(err)
+		check(r.UnreadByte())
+		if c != '(' {
+			break
+		}
+		s, err := r.readSexp()
+		check(err)
+		thread.Threads = append(thread.Threads, threadFromSexp(s))
+	}
+	check(r.expectEOL())
+	return thread
+}
+
+// ResponseStatusData contains the mailbox counts from a STATUS
+// message.  Only the items that were requested are filled in.
+type ResponseStatusData struct {
+	Mailbox       string
+	Messages      int
+	Recent        int
+	UIDNext       int
+	UIDValidity   uint32
+	Unseen        int
+	HighestModSeq uint64
+}
+
+func (r *reader) readSTATUS() *ResponseStatusData {
+	// mailbox SP "(" [status-att-list] ")"
+	mailbox, err := r.readAstring()
+	check(err)
+	check(r.expect(" "))
+
+	s, err := r.readSexp()
+	check(err)
+	if len(s)%2 != 0 {
+		panic(os.NewError("status sexp must have even number of items"))
+	}
+	status := &ResponseStatusData{Mailbox: mailbox}
+	for i := 0; i < len(s); i += 2 {
+		// Only convert the values of known items; others, like
+		// APPENDLIMIT or MAILBOXID, may be NIL or lists.
+		key, _ := s[i].(string)
+		number := func() int {
+			value, err := strconv.Atoi(s[i+1].(string))
+			check(err)
+			return value
+		}
+		switch strings.ToUpper(key) {
+		case "MESSAGES":
+			status.Messages = number()
+		case "RECENT":
+			status.Recent = number()
+		case "UIDNEXT":
+			status.UIDNext = number()
+		case "UIDVALIDITY":
+			validity, err := strconv.Atoui64(s[i+1].(string))
+			check(err)
+			status.UIDValidity = uint32(validity)
+		case "UNSEEN":
+			status.Unseen = number()
+		case "HIGHESTMODSEQ":
+			status.HighestModSeq, err = strconv.Atoui64(s[i+1].(string))
+			check(err)
+		}
+	}
+	check(r.expectEOL())
+	return status
+}
+
+// Namespace describes one namespace from a NAMESPACE message: mailbox
+// names starting with Prefix, with hierarchy delimiter Delim.
+type Namespace struct {
+	Prefix string
+	Delim  string
+}
+
+// ResponseNamespace contains the namespaces from a NAMESPACE message.
+// See RFC 2342.
+type ResponseNamespace struct {
+	Personal, Other, Shared []Namespace
+}
+
+func namespacesFromSexp(s sexp) []Namespace {
+	if s == nil {
+		return nil
+	}
+	items := s.([]sexp)
+	namespaces := make([]Namespace, len(items))
+	for i, item := range items {
+		// Extensions may follow the prefix and delimiter.
+		fields := item.([]sexp)
+		// The prefix may come as a literal.
+		if prefix := nilOrString(fields[0]); prefix != nil {
+			namespaces[i].Prefix = *prefix
+		}
+		if delim := nilOrString(fields[1]); delim != nil {
+			namespaces[i].Delim = *delim
+		}
+	}
+	return namespaces
+}
+
+func (r *reader) readNAMESPACE() *ResponseNamespace {
+	// Namespace SP Namespace SP Namespace, each NIL or a list.
+	var lists [3]sexp
+	for i := range lists {
+		if i > 0 {
+			check(r.expect(" "))
+		}
+		c, err := r.ReadByte()
+		check(err)
+		check(r.UnreadByte())
+		if c == '(' {
+			lists[i], err = r.readSexp()
+		} else {
+			err = r.expect("NIL")
+		}
+		check(err)
+	}
+	check(r.expectEOL())
+	return &ResponseNamespace{
+		Personal: namespacesFromSexp(lists[0]),
+		Other:    namespacesFromSexp(lists[1]),
+		Shared:   namespacesFromSexp(lists[2]),
+	}
+}
+
+// ResponseID contains the server identification from an ID message.
+// See RFC 2971.
+type ResponseID struct {
+	Params map[string]string
+}
+
+func (r *reader) readID() *ResponseID {
+	params := make(map[string]string)
+	c, err := r.ReadByte()
+	check(err)
+	check(r.UnreadByte())
+	if c == '(' {
+		s, err := r.readSexp()
+		check(err)
+		if len(s)%2 != 0 {
+			panic(os.NewError("id sexp must have even number of items"))
+		}
+		for i := 0; i < len(s); i += 2 {
+			name := nilOrString(s[i])
+			if name == nil {
+				panic(os.NewError("id field name is NIL"))
+			}
+			if value := nilOrString(s[i+1]); value != nil {
+				params[*name] = *value
+			}
+		}
+	} else {
+		check(r.expect("NIL"))
+	}
+	check(r.expectEOL())
+	return &ResponseID{params}
+}
+
+// ResponseEnabled contains the extensions turned on by an ENABLE
+// command.  See RFC 5161.
+type ResponseEnabled struct {
+	Capabilities []string
+}
+
+func (r *reader) readENABLED() *ResponseEnabled {
+	caps := make([]string, 0)
+	for {
+		cap, err := r.readToken()
+		check(err)
+		if len(cap) == 0 {
+			break
+		}
+		caps = append(caps, cap)
+	}
+	check(r.expectEOL())
+	return &ResponseEnabled{caps}
+}
+
+// ResponseVanished contains the UIDs of messages that were expunged,
+// reported by a QRESYNC-enabled server in place of EXPUNGE.  Earlier
+// is set when the expunges happened before the current command, as
+// when resynchronizing.  See RFC 7162 section 3.2.10.
+type ResponseVanished struct {
+	Earlier bool
+	UIDs    SeqSet
+}
+
+func (r *reader) readVANISHED() *ResponseVanished {
+	vanished := &ResponseVanished{}
+	c, err := r.ReadByte()
+	check(err)
+	check(r.UnreadByte())
+	if c == '(' {
+		s, err := r.readSexp()
+		check(err)
+		if len(s) != 1 || s[0] != "EARLIER" {
+			panic(fmt.Errorf("unexpected vanished tag %v", s))
+		}
+		vanished.Earlier = true
+		check(r.expect(" "))
+	}
+	vanished.UIDs = r.readSeqSet()
+	check(r.expectEOL())
+	return vanished
+}
+
+// QuotaResource is the usage and limit of one resource under a quota
+// root, like QuotaStorage.
+type QuotaResource struct {
+	Name         string
+	Usage, Limit uint64
+}
+
+// ResponseQuota contains the resources of a quota root from a QUOTA
+// message.  See RFC 9208 section 7.1.
+type ResponseQuota struct {
+	Root      string
+	Resources []QuotaResource
+}
+
+func (r *reader) readQUOTA() *ResponseQuota {
+	// quota-root-name SP "(" quota-resource *(SP quota-resource) ")"
+	root, err := r.readAstring()
+	check(err)
+	check(r.expect(" "))
+	s, err := r.readParenStringList()
+	check(err)
+	check(r.expectEOL())
+
+	if len(s)%3 != 0 {
+		panic(fmt.Errorf("bad quota resource list %v", s))
+	}
+	quota := &ResponseQuota{root, make([]QuotaResource, 0, len(s)/3)}
+	for i := 0; i < len(s); i += 3 {
+		usage, err := strconv.Atoui64(s[i+1])
+		check(err)
+		limit, err := strconv.Atoui64(s[i+2])
+		check(err)
+		quota.Resources = append(quota.Resources, QuotaResource{s[i], usage, limit})
+	}
+	return quota
+}
+
+// ResponseQuotaRoot contains the quota roots of a mailbox from a
+// QUOTAROOT message.  See RFC 9208 section 7.2.
+type ResponseQuotaRoot struct {
+	Mailbox string
+	Roots   []string
+}
+
+func (r *reader) readQUOTAROOT() *ResponseQuotaRoot {
+	// mailbox *(SP quota-root-name)
+	mailbox, err := r.readAstring()
+	check(err)
+	quotaRoot := &ResponseQuotaRoot{mailbox, make([]string, 0)}
+	for {
+		c, err := r.ReadByte()
+		check(err)
+		if c != ' ' {
+			check(r.UnreadByte())
+			break
+		}
+		root, err := r.readAstring()
+		check(err)
+		quotaRoot.Roots = append(quotaRoot.Roots, root)
+	}
+	check(r.expectEOL())
+	return quotaRoot
+}
+
 // ResponseExists contains the message count of a mailbox.
 type ResponseExists struct {
 	Count int
@@ -373,6 +1132,18 @@ type ResponseRecent struct {
 	Count int
 }
 
+// ResponseExpunge contains the sequence number of a message that was
+// permanently removed from the mailbox.
+type ResponseExpunge struct {
+	Msg int
+}
+
+// ResponseBye contains the text of a BYE message, sent when the server
+// is about to close the connection.
+type ResponseBye struct {
+	Text string
+}
+
 func (r *reader) readUntagged() (resp interface{}, outErr os.Error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -390,10 +1161,39 @@ func (r *reader) readUntagged() (resp interface{}, outErr os.Error) {
 	switch command {
 	case "CAPABILITY":
 		return r.readCAPABILITY(), nil
-	case "LIST":
+	case "LIST", "LSUB":
 		return r.readLIST(), nil
 	case "FLAGS":
 		return r.readFLAGS(), nil
+	case "SEARCH":
+		return r.readSEARCH(), nil
+	case "ESEARCH":
+		return r.readESEARCH(), nil
+	case "QUOTA":
+		return r.readQUOTA(), nil
+	case "QUOTAROOT":
+		return r.readQUOTAROOT(), nil
+	case "SORT":
+		return r.readSORT(), nil
+	case "THREAD":
+		return r.readTHREAD(), nil
+	case "NAMESPACE":
+		return r.readNAMESPACE(), nil
+	case "ID":
+		return r.readID(), nil
+	case "ENABLED":
+		return r.readENABLED(), nil
+	case "VANISHED":
+		if !r.enabled["QRESYNC"] {
+			return nil, os.NewError("VANISHED response without QRESYNC enabled")
+		}
+		return r.readVANISHED(), nil
+	case "STATUS":
+		return r.readSTATUS(), nil
+	case "BYE":
+		text, err := r.readToEOL()
+		check(err)
+		return &ResponseBye{text}, nil
 	case "OK", "NO", "BAD":
 		resp, err := r.readStatus(command)
 		check(err)
@@ -421,6 +1221,9 @@ func (r *reader) readUntagged() (resp interface{}, outErr os.Error) {
 		case "RECENT":
 			check(r.expectEOL())
 			return &ResponseRecent{num}, nil
+		case "EXPUNGE":
+			check(r.expectEOL())
+			return &ResponseExpunge{num}, nil
 		case "FETCH":
 			return r.readFETCH(num), nil
 		}
@@ -13,7 +13,7 @@ type readerTest struct {
 }
 
 func (rt readerTest) Run(t *testing.T) {
-	r := &reader{newParser(bytes.NewBufferString(rt.input))}
+	r := newReader(bytes.NewBufferString(rt.input))
 	tag, resp, err := r.readResponse()
 	check(err)
 	if tag != rt.expectedTag {
@@ -25,7 +25,49 @@ func (rt readerTest) Run(t *testing.T) {
 }
 
 
+func TestCapabilitiesHas(t *testing.T) {
+	caps := &ResponseCapabilities{[]string{"IMAP4rev1", "IDLE", "AUTH=PLAIN"}}
+	if !caps.Has("idle") || !caps.Has("AUTH=PLAIN") {
+		t.Fatalf("%v should have IDLE and AUTH=PLAIN", caps.Capabilities)
+	}
+	if caps.Has("MOVE") {
+		t.Fatalf("%v should not have MOVE", caps.Capabilities)
+	}
+}
+
+func TestListRole(t *testing.T) {
+	list := &ResponseList{Attributes: []string{"\\HasNoChildren", "\\sent"}}
+	if !list.Has("\\hasnochildren") {
+		t.Errorf("expected \\HasNoChildren in %v", list.Attributes)
+	}
+	if role := list.Role(); role != RoleSent {
+		t.Errorf("expected role %q, got %q", RoleSent, role)
+	}
+	list = &ResponseList{Attributes: []string{"\\Marked"}}
+	if role := list.Role(); role != "" {
+		t.Errorf("expected no role, got %q", role)
+	}
+}
+
+func TestVanished(t *testing.T) {
+	input := "* VANISHED (EARLIER) 41,43:116,118\r\n"
+	r := newReader(bytes.NewBufferString(input))
+	if _, _, err := r.readResponse(); err == nil {
+		t.Fatalf("VANISHED accepted without QRESYNC")
+	}
+
+	r = newReader(bytes.NewBufferString(input))
+	r.enable([]string{"QRESYNC"})
+	_, resp, err := r.readResponse()
+	check(err)
+	expected := &ResponseVanished{true, SeqSet{{41, 41}, {43, 116}, {118, 118}}}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Fatalf("DeepEqual(%#v, %#v)", resp, expected)
+	}
+}
+
 func TestProtocol(t *testing.T) {
+	no := false
 	tests := []readerTest{
 		readerTest{
 			"* OK Gimap ready for requests from 12.34 u6if.369\r\n",
@@ -45,20 +87,328 @@ func TestProtocol(t *testing.T) {
 			untagged,
 			&ResponseUIDValidity{2},
 		},
+		readerTest{
+			"* OK [UIDVALIDITY 3857529045] UIDs valid.\r\n",
+			untagged,
+			&ResponseUIDValidity{3857529045},
+		},
 		readerTest{
 			"* OK [UIDNEXT 31677] Predicted next UID.\r\n",
 			untagged,
 			&ResponseUIDNext{31677},
 		},
+		readerTest{
+			"* OK [UNSEEN 12] Message 12 is first unseen\r\n",
+			untagged,
+			&ResponseUnseen{12},
+		},
 		readerTest{
 			"a2 OK [READ-ONLY] INBOX selected. (Success)\r\n",
 			tag(2),
 			&ResponseStatus{
 				status: OK,
-				code:"READ-ONLY",
+				code:&ResponseReadOnly{},
 				text:"INBOX selected. (Success)",
 			},
 		},
+		readerTest{
+			"* 12 FETCH (FLAGS (\\Seen \\Flagged $Important))\r\n",
+			untagged,
+			&ResponseFetch{
+				Msg: 12,
+				Flags: []Flag{FlagSeen, FlagFlagged, "$Important"},
+			},
+		},
+		readerTest{
+			"* 23 FETCH (FLAGS (\\Seen) UID 4827313)\r\n",
+			untagged,
+			&ResponseFetch{
+				Msg: 23,
+				UID: 4827313,
+				Flags: []Flag{FlagSeen},
+			},
+		},
+		readerTest{
+			"* 3 EXPUNGE\r\n",
+			untagged,
+			&ResponseExpunge{3},
+		},
+		readerTest{
+			"a3 OK [READ-WRITE] SELECT completed\r\n",
+			tag(3),
+			&ResponseStatus{
+				status: OK,
+				code:&ResponseReadWrite{},
+				text:"SELECT completed",
+			},
+		},
+		readerTest{
+			"* SEARCH 2 84 882\r\n",
+			untagged,
+			&ResponseSearch{IDs: []uint32{2, 84, 882}},
+		},
+		readerTest{
+			"* SEARCH\r\n",
+			untagged,
+			&ResponseSearch{IDs: []uint32{}},
+		},
+		readerTest{
+			"* SEARCH 2 5 6 (MODSEQ 917162500)\r\n",
+			untagged,
+			&ResponseSearch{IDs: []uint32{2, 5, 6}, ModSeq: 917162500},
+		},
+		readerTest{
+			"* STATUS blurdybloop (MESSAGES 231 UIDNEXT 44292)\r\n",
+			untagged,
+			&ResponseStatusData{
+				Mailbox: "blurdybloop",
+				Messages: 231,
+				UIDNext: 44292,
+			},
+		},
+		readerTest{
+			"* STATUS \"Sent Items\" (UNSEEN 0 UIDVALIDITY 1857529045)\r\n",
+			untagged,
+			&ResponseStatusData{
+				Mailbox: "Sent Items",
+				UIDValidity: 1857529045,
+			},
+		},
+		readerTest{
+			"* STATUS Archive (UIDVALIDITY 3857529045)\r\n",
+			untagged,
+			&ResponseStatusData{
+				Mailbox: "Archive",
+				UIDValidity: 3857529045,
+			},
+		},
+		readerTest{
+			"* STATUS INBOX (MESSAGES 3 APPENDLIMIT NIL MAILBOXID (F2212ea87-6097-4256-9d51-71338625) SIZE 1024)\r\n",
+			untagged,
+			&ResponseStatusData{
+				Mailbox: "INBOX",
+				Messages: 3,
+			},
+		},
+		readerTest{
+			"+ Ready for literal data\r\n",
+			continuation,
+			&ResponseContinuation{"Ready for literal data"},
+		},
+		readerTest{
+			"a4 OK [APPENDUID 38505 3955] APPEND completed\r\n",
+			tag(4),
+			&ResponseStatus{
+				status: OK,
+				code:&ResponseAppendUID{38505, SeqSet{{3955, 3955}}},
+				text:"APPEND completed",
+			},
+		},
+		readerTest{
+			"a5 NO [TRYCREATE] Mailbox doesn't exist: Archive\r\n",
+			tag(5),
+			&ResponseStatus{
+				status: NO,
+				code:&ResponseTryCreate{},
+				text:"Mailbox doesn't exist: Archive",
+			},
+		},
+		readerTest{
+			"* LSUB () \"/\" \"Archive\"\r\n",
+			untagged,
+			&ResponseList{Attributes: []string{}, Delim: "/", Name: "Archive"},
+		},
+		readerTest{
+			"* LIST (\\HasNoChildren \\All) \"/\" \"[Gmail]/All Mail\"\r\n",
+			untagged,
+			&ResponseList{
+				Children:   &no,
+				Attributes: []string{"\\HasNoChildren", "\\All"},
+				Delim:      "/",
+				Name:       "[Gmail]/All Mail",
+			},
+		},
+		readerTest{
+			"* LIST (\\NonExistent) NIL Foo (\"CHILDINFO\" (\"SUBSCRIBED\"))\r\n",
+			untagged,
+			&ResponseList{
+				Selectable: &no,
+				Attributes: []string{"\\NonExistent"},
+				Name:       "Foo",
+				ChildInfo:  []string{"SUBSCRIBED"},
+			},
+		},
+		readerTest{
+			"* BYE IMAP4rev1 Server logging out\r\n",
+			untagged,
+			&ResponseBye{"IMAP4rev1 Server logging out"},
+		},
+		readerTest{
+			"* OK [CAPABILITY IMAP4rev1 IDLE AUTH=PLAIN] Dovecot ready.\r\n",
+			untagged,
+			&ResponseCapabilities{[]string{"IMAP4rev1", "IDLE", "AUTH=PLAIN"}},
+		},
+		readerTest{
+			"a1 OK [CAPABILITY IMAP4rev1 MOVE] Logged in\r\n",
+			tag(1),
+			&ResponseStatus{
+				status: OK,
+				code:&ResponseCapabilities{[]string{"IMAP4rev1", "MOVE"}},
+				text:"Logged in",
+			},
+		},
+		readerTest{
+			"* NAMESPACE ((\"\" \"/\")) ((\"~\" \"/\")) ((\"#shared/\" \"/\")(\"#public/\" \"/\" \"X-PARAM\" (\"FLAG1\")))\r\n",
+			untagged,
+			&ResponseNamespace{
+				Personal: []Namespace{{"", "/"}},
+				Other: []Namespace{{"~", "/"}},
+				Shared: []Namespace{{"#shared/", "/"}, {"#public/", "/"}},
+			},
+		},
+		readerTest{
+			"* NAMESPACE ((\"INBOX.\" \".\")) NIL NIL\r\n",
+			untagged,
+			&ResponseNamespace{
+				Personal: []Namespace{{"INBOX.", "."}},
+			},
+		},
+		readerTest{
+			"* NAMESPACE (({6}\r\nINBOX. \".\")) NIL NIL\r\n",
+			untagged,
+			&ResponseNamespace{
+				Personal: []Namespace{{"INBOX.", "."}},
+			},
+		},
+		readerTest{
+			"* ID (\"name\" \"Cyrus\" \"version\" \"1.5\" \"os\" NIL)\r\n",
+			untagged,
+			&ResponseID{map[string]string{"name": "Cyrus", "version": "1.5"}},
+		},
+		readerTest{
+			"* ID (\"name\" {5}\r\nDovec \"support-url\" NIL)\r\n",
+			untagged,
+			&ResponseID{map[string]string{"name": "Dovec"}},
+		},
+		readerTest{
+			"* ID NIL\r\n",
+			untagged,
+			&ResponseID{map[string]string{}},
+		},
+		readerTest{
+			"* ENABLED CONDSTORE QRESYNC\r\n",
+			untagged,
+			&ResponseEnabled{[]string{"CONDSTORE", "QRESYNC"}},
+		},
+		readerTest{
+			"* 4 FETCH (UID 8 MODSEQ (12121231000) FLAGS (\\Seen))\r\n",
+			untagged,
+			&ResponseFetch{
+				Msg: 4,
+				UID: 8,
+				ModSeq: 12121231000,
+				Flags: []Flag{FlagSeen},
+			},
+		},
+		readerTest{
+			"* OK [HIGHESTMODSEQ 715194045007] Highest\r\n",
+			untagged,
+			&ResponseHighestModSeq{715194045007},
+		},
+		readerTest{
+			"a6 OK [MODIFIED 7,9] Conditional STORE failed\r\n",
+			tag(6),
+			&ResponseStatus{
+				status: OK,
+				code:&ResponseModified{SeqSet{{7, 7}, {9, 9}}},
+				text:"Conditional STORE failed",
+			},
+		},
+		readerTest{
+			"* OK [COPYUID 432432 42:43 112:113] Moved UIDs.\r\n",
+			untagged,
+			&ResponseCopyUID{432432, SeqSet{{42, 43}}, SeqSet{{112, 113}}},
+		},
+		readerTest{
+			"a7 OK [COPYUID 38505 304,319:320 3956:3958] Done\r\n",
+			tag(7),
+			&ResponseStatus{
+				status: OK,
+				code:&ResponseCopyUID{38505, SeqSet{{304, 304}, {319, 320}}, SeqSet{{3956, 3958}}},
+				text:"Done",
+			},
+		},
+		readerTest{
+			"* ESEARCH (TAG \"a5\") UID MIN 4 MAX 12 COUNT 5 ALL 4:5,7,9:12\r\n",
+			untagged,
+			&ResponseESearch{
+				Tag:   "a5",
+				UID:   true,
+				Min:   4,
+				Max:   12,
+				Count: 5,
+				All:   SeqSet{{4, 5}, {7, 7}, {9, 12}},
+			},
+		},
+		readerTest{
+			"* ESEARCH (TAG \"a7\") UID PARTIAL (1:2 4,9) RELEVANCY (90 45) COUNT 2\r\n",
+			untagged,
+			&ResponseESearch{Tag: "a7", UID: true, Count: 2},
+		},
+		readerTest{
+			"* ESEARCH (TAG \"a6\") COUNT 0\r\n",
+			untagged,
+			&ResponseESearch{Tag: "a6"},
+		},
+		readerTest{
+			"* QUOTA \"\" (STORAGE 10 512 MESSAGE 1 100)\r\n",
+			untagged,
+			&ResponseQuota{"", []QuotaResource{
+				QuotaResource{QuotaStorage, 10, 512},
+				QuotaResource{QuotaMessage, 1, 100},
+			}},
+		},
+		readerTest{
+			"* QUOTAROOT INBOX \"\" user.tim\r\n",
+			untagged,
+			&ResponseQuotaRoot{"INBOX", []string{"", "user.tim"}},
+		},
+		readerTest{
+			"* QUOTAROOT comp.mail.mime\r\n",
+			untagged,
+			&ResponseQuotaRoot{"comp.mail.mime", []string{}},
+		},
+		readerTest{
+			"* SORT 2 84 882\r\n",
+			untagged,
+			&ResponseSort{IDs: []uint32{2, 84, 882}},
+		},
+		readerTest{
+			"* SORT\r\n",
+			untagged,
+			&ResponseSort{IDs: []uint32{}},
+		},
+		readerTest{
+			"* THREAD (2)(3 6 (4 23)(44 7 96))((11)(12))\r\n",
+			untagged,
+			&ResponseThread{[]*ThreadNode{
+				&ThreadNode{2, nil},
+				&ThreadNode{3, []*ThreadNode{
+					&ThreadNode{6, []*ThreadNode{
+						&ThreadNode{4, []*ThreadNode{&ThreadNode{23, nil}}},
+						&ThreadNode{44, []*ThreadNode{
+							&ThreadNode{7, []*ThreadNode{&ThreadNode{96, nil}}},
+						}},
+					}},
+				}},
+				&ThreadNode{0, []*ThreadNode{&ThreadNode{11, nil}, &ThreadNode{12, nil}}},
+			}},
+		},
+		readerTest{
+			"* THREAD\r\n",
+			untagged,
+			&ResponseThread{[]*ThreadNode{}},
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,124 @@
+package imap
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Quota resource names.  See RFC 9208 section 5.
+const (
+	// Sum of message sizes, in units of 1024 octets.
+	QuotaStorage = "STORAGE"
+	// Number of messages.
+	QuotaMessage = "MESSAGE"
+	// Number of mailboxes.
+	QuotaMailbox = "MAILBOX"
+	// Size of annotations, in units of 1024 octets.
+	QuotaAnnotationStorage = "ANNOTATION-STORAGE"
+)
+
+func (imap *IMAP) checkQuota() os.Error {
+	hasQuota, err := imap.HasCapability("QUOTA")
+	if err != nil {
+		return err
+	}
+	if !hasQuota {
+		return os.NewError("imap: server does not support QUOTA")
+	}
+	return nil
+}
+
+// GetQuota returns the usage and limits of a quota root.
+func (imap *IMAP) GetQuota(root string) (*ResponseQuota, os.Error) {
+	if err := imap.checkQuota(); err != nil {
+		return nil, err
+	}
+	resp, err := imap.SendSync("GETQUOTA %s", quote(root))
+	if err != nil {
+		return nil, err
+	}
+
+	var quota *ResponseQuota
+	for _, extra := range resp.extra {
+		if q, ok := extra.(*ResponseQuota); ok && quota == nil && q.Root == root {
+			quota = q
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	if quota == nil {
+		return nil, os.NewError("imap: no QUOTA response")
+	}
+	return quota, nil
+}
+
+// GetQuotaRoot returns the quota roots that mailbox counts against,
+// along with the usage and limits of each of them.
+func (imap *IMAP) GetQuotaRoot(mailbox string) (*ResponseQuotaRoot, []*ResponseQuota, os.Error) {
+	if err := imap.checkQuota(); err != nil {
+		return nil, nil, err
+	}
+	resp, err := imap.SendSync("GETQUOTAROOT %s", quote(mailbox))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var root *ResponseQuotaRoot
+	quotas := make([]*ResponseQuota, 0)
+	for _, extra := range resp.extra {
+		switch extra := extra.(type) {
+		case *ResponseQuotaRoot:
+			if root == nil {
+				root = extra
+			} else {
+				imap.Unsolicited <- extra
+			}
+		case *ResponseQuota:
+			quotas = append(quotas, extra)
+		default:
+			imap.Unsolicited <- extra
+		}
+	}
+	if root == nil {
+		return nil, nil, os.NewError("imap: no QUOTAROOT response")
+	}
+	return root, quotas, nil
+}
+
+// SetQuota changes the limits of a quota root, given as resource names
+// like QuotaStorage mapped to their new limits.  Resources left out
+// become unlimited.  Usually only administrators may do this.  The
+// server's report of the new quota is returned if it sent one, and is
+// nil otherwise.
+func (imap *IMAP) SetQuota(root string, limits map[string]uint64) (*ResponseQuota, os.Error) {
+	if err := imap.checkQuota(); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = fmt.Sprintf("%s %d", name, limits[name])
+	}
+
+	resp, err := imap.SendSync("SETQUOTA %s (%s)", quote(root), strings.Join(fields, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	var quota *ResponseQuota
+	for _, extra := range resp.extra {
+		if q, ok := extra.(*ResponseQuota); ok && quota == nil && q.Root == root {
+			quota = q
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	return quota, nil
+}
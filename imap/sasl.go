@@ -0,0 +1,247 @@
+package imap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SASLMechanism is the client side of a SASL authentication mechanism
+// (RFC 4422), for use with Authenticate.
+type SASLMechanism interface {
+	// Name returns the mechanism name, like "PLAIN".
+	Name() string
+
+	// Start begins the exchange.  It returns the initial response, or
+	// nil if the mechanism waits for a challenge from the server.
+	Start() ([]byte, os.Error)
+
+	// Next returns the response to a challenge from the server.
+	Next(challenge []byte) ([]byte, os.Error)
+}
+
+// saslCompleter is implemented by mechanisms that must see the whole
+// exchange, like SCRAM's check of the server signature, before a
+// successful login can be trusted.
+type saslCompleter interface {
+	complete() os.Error
+}
+
+func encodeBase64(data []byte) string {
+	buf := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(buf, data)
+	return string(buf)
+}
+
+func decodeBase64(text string) ([]byte, os.Error) {
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(buf, []byte(text))
+	if err != nil {
+		return nil, err
+	}
+	return buf[0:n], nil
+}
+
+// saslName escapes a username for a GS2 header (RFC 5801) or SCRAM
+// message.
+func saslName(name string) string {
+	name = strings.Replace(name, "=", "=3D", -1)
+	return strings.Replace(name, ",", "=2C", -1)
+}
+
+// Authenticate logs in with a SASL mechanism, like Auth does with a
+// password.  The initial response is sent with the command if the
+// server supports SASL-IR (RFC 4959).
+func (imap *IMAP) Authenticate(mech SASLMechanism) (string, []string, os.Error) {
+	ir, err := mech.Start()
+	if err != nil {
+		return "", nil, err
+	}
+
+	command := "AUTHENTICATE " + mech.Name()
+	if ir != nil {
+		hasIR, err := imap.HasCapability("SASL-IR")
+		if err != nil {
+			return "", nil, err
+		}
+		if hasIR {
+			if len(ir) == 0 {
+				command += " ="
+			} else {
+				command += " " + encodeBase64(ir)
+			}
+			ir = nil
+		}
+	}
+
+	ch := make(chan interface{}, 1)
+	err = imap.Send(ch, "%s", command)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// If the mechanism fails, the exchange is cancelled and its error
+	// is returned in place of the server's response to the cancel.
+	var mechErr os.Error
+	var resp *ResponseStatus
+	extra := make([]interface{}, 0)
+L:
+	for {
+		r, ok := <-ch
+		if !ok {
+			return "", nil, ErrClosed
+		}
+		switch r := r.(type) {
+		case *ResponseContinuation:
+			var data []byte
+			if ir != nil {
+				data, ir = ir, nil
+			} else {
+				challenge, err := decodeBase64(r.Text)
+				if err == nil {
+					data, err = mech.Next(challenge)
+				}
+				if err != nil {
+					mechErr = err
+					_, err = imap.conn.Write([]byte("*\r\n"))
+					if err != nil {
+						return "", nil, err
+					}
+					continue
+				}
+			}
+			_, err = imap.conn.Write([]byte(encodeBase64(data) + "\r\n"))
+			if err != nil {
+				return "", nil, err
+			}
+		case *ResponseStatus:
+			resp = r
+			break L
+		default:
+			extra = append(extra, r)
+		}
+	}
+
+	if len(extra) > 0 {
+		resp.extra = extra
+	}
+	if mechErr != nil {
+		return "", nil, mechErr
+	}
+	if resp.status != OK {
+		return "", nil, &IMAPError{resp.status, resp.code, resp.text}
+	}
+	if c, ok := mech.(saslCompleter); ok {
+		err = c.complete()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return resp.text, imap.loginCapabilities(resp), nil
+}
+
+type plainAuth struct {
+	identity, username, password string
+}
+
+// PlainAuth returns the PLAIN mechanism (RFC 4616).  identity is
+// usually empty, meaning to act as username.
+func PlainAuth(identity string, username string, password string) SASLMechanism {
+	return &plainAuth{identity, username, password}
+}
+
+func (a *plainAuth) Name() string {
+	return "PLAIN"
+}
+
+func (a *plainAuth) Start() ([]byte, os.Error) {
+	return []byte(a.identity + "\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(challenge []byte) ([]byte, os.Error) {
+	return nil, os.NewError("imap: unexpected PLAIN challenge")
+}
+
+type loginAuth struct {
+	username, password string
+	step               int
+}
+
+// LoginAuth returns the non-standard LOGIN mechanism, which sends the
+// username and password in reply to two challenges.
+func LoginAuth(username string, password string) SASLMechanism {
+	return &loginAuth{username: username, password: password}
+}
+
+func (a *loginAuth) Name() string {
+	return "LOGIN"
+}
+
+func (a *loginAuth) Start() ([]byte, os.Error) {
+	a.step = 0
+	return nil, nil
+}
+
+func (a *loginAuth) Next(challenge []byte) ([]byte, os.Error) {
+	a.step++
+	switch a.step {
+	case 1:
+		return []byte(a.username), nil
+	case 2:
+		return []byte(a.password), nil
+	}
+	return nil, os.NewError("imap: unexpected LOGIN challenge")
+}
+
+type xoauth2Auth struct {
+	username, token string
+}
+
+// XOAuth2Auth returns Google's XOAUTH2 mechanism, which logs in with
+// an OAuth 2.0 access token.
+func XOAuth2Auth(username string, token string) SASLMechanism {
+	return &xoauth2Auth{username, token}
+}
+
+func (a *xoauth2Auth) Name() string {
+	return "XOAUTH2"
+}
+
+func (a *xoauth2Auth) Start() ([]byte, os.Error) {
+	return []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(challenge []byte) ([]byte, os.Error) {
+	// The challenge is a JSON error; an empty reply lets the server
+	// finish with a NO.
+	return []byte{}, nil
+}
+
+type oauthBearerAuth struct {
+	username, host string
+	port           int
+	token          string
+}
+
+// OAuthBearerAuth returns the OAUTHBEARER mechanism (RFC 7628), which
+// logs in with an OAuth 2.0 bearer token.  host and port name the
+// server being connected to.
+func OAuthBearerAuth(username string, host string, port int, token string) SASLMechanism {
+	return &oauthBearerAuth{username, host, port, token}
+}
+
+func (a *oauthBearerAuth) Name() string {
+	return "OAUTHBEARER"
+}
+
+func (a *oauthBearerAuth) Start() ([]byte, os.Error) {
+	return []byte(fmt.Sprintf("n,a=%s,\x01host=%s\x01port=%d\x01auth=Bearer %s\x01\x01",
+		saslName(a.username), a.host, a.port, a.token)), nil
+}
+
+func (a *oauthBearerAuth) Next(challenge []byte) ([]byte, os.Error) {
+	// The challenge is a JSON error, which must be acknowledged with
+	// a single ^A (RFC 7628 section 3.2.3).
+	return []byte("\x01"), nil
+}
@@ -0,0 +1,121 @@
+package imap
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+type saslStep struct {
+	challenge, response string
+}
+
+func testMechanism(t *testing.T, mech SASLMechanism, ir string, steps []saslStep) {
+	data, err := mech.Start()
+	testError(t, err, "%s start", mech.Name())
+	if !bytes.Equal(data, []byte(ir)) {
+		t.Fatalf("%s: expected initial response %q, got %q", mech.Name(), ir, data)
+	}
+	for _, step := range steps {
+		data, err = mech.Next([]byte(step.challenge))
+		testError(t, err, "%s challenge %q", mech.Name(), step.challenge)
+		if !bytes.Equal(data, []byte(step.response)) {
+			t.Fatalf("%s: expected response %q, got %q", mech.Name(), step.response, data)
+		}
+	}
+}
+
+func TestSASLMechanisms(t *testing.T) {
+	testMechanism(t, PlainAuth("", "tim", "tanstaaftanstaaf"),
+		"\x00tim\x00tanstaaftanstaaf", nil)
+	testMechanism(t, LoginAuth("tim", "tanstaaftanstaaf"),
+		"", []saslStep{{"Username:", "tim"}, {"Password:", "tanstaaftanstaaf"}})
+	testMechanism(t, XOAuth2Auth("someuser@example.com", "ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg"),
+		"user=someuser@example.com\x01auth=Bearer ya29.vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg\x01\x01", nil)
+	testMechanism(t, OAuthBearerAuth("user@example.com", "server.example.com", 143, "vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg=="),
+		"n,a=user@example.com,\x01host=server.example.com\x01port=143\x01auth=Bearer vF9dft4qmTc2Nvb3RlckBhdHRhdmlzdGEuY29tCg==\x01\x01",
+		[]saslStep{{`{"status":"invalid_token"}`, "\x01"}})
+}
+
+func TestBase64(t *testing.T) {
+	if enc := encodeBase64([]byte("\x00tim\x00tanstaaftanstaaf")); enc != "AHRpbQB0YW5zdGFhZnRhbnN0YWFm" {
+		t.Fatalf("encoded to %q", enc)
+	}
+	dec, err := decodeBase64("AHRpbQB0YW5zdGFhZnRhbnN0YWFm")
+	testError(t, err, "decoding")
+	if string(dec) != "\x00tim\x00tanstaaftanstaaf" {
+		t.Fatalf("decoded to %q", dec)
+	}
+}
+
+// failingAuth sends ir as its initial response and answers any
+// challenge with err.
+type failingAuth struct {
+	ir  []byte
+	err os.Error
+}
+
+func (a *failingAuth) Name() string {
+	return "X-TEST"
+}
+
+func (a *failingAuth) Start() ([]byte, os.Error) {
+	return a.ir, nil
+}
+
+func (a *failingAuth) Next(challenge []byte) ([]byte, os.Error) {
+	return nil, a.err
+}
+
+func TestAuthenticate(t *testing.T) {
+	imap, done := startScripted(t, "* OK [CAPABILITY IMAP4rev1 AUTH=LOGIN] ready\r\n", []scriptStep{
+		scriptStep{"a0 AUTHENTICATE LOGIN\r\n", "+ VXNlcm5hbWU6\r\n"},
+		scriptStep{encodeBase64([]byte("tim")) + "\r\n", "+ UGFzc3dvcmQ6\r\n"},
+		scriptStep{encodeBase64([]byte("tanstaaftanstaaf")) + "\r\n",
+			"a0 OK [CAPABILITY IMAP4rev1 IDLE] logged in\r\n"},
+	})
+
+	text, caps, err := imap.Authenticate(LoginAuth("tim", "tanstaaftanstaaf"))
+	testError(t, err, "authenticate")
+	if text != "logged in" || len(caps) != 2 || caps[1] != "IDLE" {
+		t.Errorf("got %q %v", text, caps)
+	}
+	<-done
+}
+
+func TestAuthenticateEmptyInitialResponse(t *testing.T) {
+	// With SASL-IR, an empty initial response is sent as "=".
+	imap, done := startScripted(t, "* OK [CAPABILITY IMAP4rev1 SASL-IR] ready\r\n", []scriptStep{
+		scriptStep{"a0 AUTHENTICATE X-TEST =\r\n", "a0 OK logged in\r\n"},
+	})
+
+	_, _, err := imap.Authenticate(&failingAuth{[]byte{}, nil})
+	testError(t, err, "authenticate")
+	<-done
+}
+
+func TestAuthenticateCancel(t *testing.T) {
+	imap, done := startScripted(t, "* OK [CAPABILITY IMAP4rev1] ready\r\n", []scriptStep{
+		scriptStep{"a0 AUTHENTICATE X-TEST\r\n", "+ Y2hhbGxlbmdl\r\n"},
+		scriptStep{"*\r\n", "a0 BAD cancelled\r\n"},
+	})
+
+	mechErr := os.NewError("no thanks")
+	_, _, err := imap.Authenticate(&failingAuth{nil, mechErr})
+	if err != mechErr {
+		t.Errorf("expected the mechanism's error, got %v", err)
+	}
+	<-done
+}
+
+func TestAuthenticateWithoutSASLIR(t *testing.T) {
+	// Without SASL-IR, the initial response waits for the first "+".
+	imap, done := startScripted(t, "* OK [CAPABILITY IMAP4rev1] ready\r\n", []scriptStep{
+		scriptStep{"a0 AUTHENTICATE PLAIN\r\n", "+ \r\n"},
+		scriptStep{"AHRpbQB0YW5zdGFhZnRhbnN0YWFm\r\n", "a0 OK logged in\r\n"},
+	})
+
+	_, _, err := imap.Authenticate(PlainAuth("", "tim", "tanstaaftanstaaf"))
+	testError(t, err, "authenticate")
+	<-done
+}
@@ -0,0 +1,162 @@
+package imap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// scramAuth implements the SCRAM mechanisms (RFC 5802), without
+// channel binding.
+type scramAuth struct {
+	name               string
+	hash               func() hash.Hash
+	username, password string
+
+	// Client nonce; generated by Start unless already set.
+	nonce string
+
+	step            int
+	clientFirstBare string
+	serverSignature []byte
+}
+
+// ScramSHA1Auth returns the SCRAM-SHA-1 mechanism (RFC 5802).  The
+// server's signature is checked, so a successful login also proves
+// the server knows the password.  The password is used as given,
+// without SASLprep normalization.
+func ScramSHA1Auth(username string, password string) SASLMechanism {
+	return &scramAuth{name: "SCRAM-SHA-1", hash: sha1.New, username: username, password: password}
+}
+
+// ScramSHA256Auth returns the SCRAM-SHA-256 mechanism (RFC 7677).  See
+// ScramSHA1Auth.
+func ScramSHA256Auth(username string, password string) SASLMechanism {
+	return &scramAuth{name: "SCRAM-SHA-256", hash: sha256.New, username: username, password: password}
+}
+
+func (a *scramAuth) Name() string {
+	return a.name
+}
+
+func (a *scramAuth) Start() ([]byte, os.Error) {
+	if a.nonce == "" {
+		buf := make([]byte, 18)
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		a.nonce = encodeBase64(buf)
+	}
+	a.step = 0
+	a.serverSignature = nil
+	a.clientFirstBare = "n=" + saslName(a.username) + ",r=" + a.nonce
+	return []byte("n,," + a.clientFirstBare), nil
+}
+
+func (a *scramAuth) Next(challenge []byte) ([]byte, os.Error) {
+	a.step++
+	switch a.step {
+	case 1:
+		return a.clientFinal(string(challenge))
+	case 2:
+		attrs := scramAttributes(string(challenge))
+		if e, ok := attrs["e"]; ok {
+			return nil, os.NewError("imap: SCRAM server error: " + e)
+		}
+		sig, err := decodeBase64(attrs["v"])
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(sig, a.serverSignature) {
+			return nil, os.NewError("imap: SCRAM server signature mismatch")
+		}
+		a.serverSignature = nil
+		return []byte{}, nil
+	}
+	return nil, os.NewError("imap: unexpected SCRAM challenge")
+}
+
+// complete checks that the server proved itself before the exchange
+// ended.
+func (a *scramAuth) complete() os.Error {
+	if a.step != 2 || a.serverSignature != nil {
+		return os.NewError("imap: SCRAM server signature not verified")
+	}
+	return nil
+}
+
+func (a *scramAuth) clientFinal(serverFirst string) ([]byte, os.Error) {
+	attrs := scramAttributes(serverFirst)
+	nonce := attrs["r"]
+	if !strings.HasPrefix(nonce, a.nonce) || len(nonce) == len(a.nonce) {
+		return nil, os.NewError("imap: SCRAM server nonce doesn't extend ours")
+	}
+	salt, err := decodeBase64(attrs["s"])
+	if err != nil {
+		return nil, err
+	}
+	iter, err := strconv.Atoi(attrs["i"])
+	if err != nil {
+		return nil, err
+	}
+
+	salted := scramHi(a.hash, []byte(a.password), salt, iter)
+	clientKey := a.hmac(salted, "Client Key")
+	h := a.hash()
+	h.Write(clientKey)
+	storedKey := h.Sum()
+
+	clientFinal := "c=biws,r=" + nonce
+	authMessage := a.clientFirstBare + "," + serverFirst + "," + clientFinal
+
+	proof := a.hmac(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	a.serverSignature = a.hmac(a.hmac(salted, "Server Key"), authMessage)
+
+	return []byte(clientFinal + ",p=" + encodeBase64(proof)), nil
+}
+
+func (a *scramAuth) hmac(key []byte, data string) []byte {
+	mac := hmac.New(a.hash, key)
+	mac.Write([]byte(data))
+	return mac.Sum()
+}
+
+// scramHi is the Hi function of RFC 5802, which is PBKDF2 with HMAC.
+func scramHi(h func() hash.Hash, password []byte, salt []byte, iter int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum()
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iter; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum()
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// scramAttributes splits a SCRAM message like "r=...,s=...,i=4096".
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(msg, ",") {
+		if len(field) >= 2 && field[1] == '=' {
+			attrs[field[0:1]] = field[2:]
+		}
+	}
+	return attrs
+}
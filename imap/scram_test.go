@@ -0,0 +1,49 @@
+package imap
+
+import (
+	"testing"
+)
+
+func testScram(t *testing.T, mech SASLMechanism, nonce string, steps []saslStep) {
+	mech.(*scramAuth).nonce = nonce
+	testMechanism(t, mech, "n,,n=user,r="+nonce, steps)
+	testError(t, mech.(*scramAuth).complete(), "%s complete", mech.Name())
+}
+
+// Test vectors from RFC 5802 section 5.
+func TestScramSHA1(t *testing.T) {
+	testScram(t, ScramSHA1Auth("user", "pencil"), "fyko+d2lbbFgONRv9qkxdawL", []saslStep{
+		{
+			"r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+			"c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+		},
+		{"v=rmF9pqV8S7suAoZWja4dJRkFsKQ=", ""},
+	})
+}
+
+// Test vectors from RFC 7677 section 3.
+func TestScramSHA256(t *testing.T) {
+	testScram(t, ScramSHA256Auth("user", "pencil"), "rOprNGfwEbeRWgbNEkqO", []saslStep{
+		{
+			"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		},
+		{"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", ""},
+	})
+}
+
+func TestScramBadServerSignature(t *testing.T) {
+	mech := ScramSHA1Auth("user", "pencil")
+	mech.(*scramAuth).nonce = "fyko+d2lbbFgONRv9qkxdawL"
+	_, err := mech.Start()
+	testError(t, err, "start")
+	_, err = mech.Next([]byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"))
+	testError(t, err, "server-first")
+	_, err = mech.Next([]byte("v=AAAApqV8S7suAoZWja4dJRkFsKQ="))
+	if err == nil {
+		t.Fatalf("bad server signature accepted")
+	}
+	if mech.(*scramAuth).complete() == nil {
+		t.Fatalf("exchange complete without server signature")
+	}
+}
@@ -0,0 +1,274 @@
+package imap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SearchKey is a single criterion for Search.  Keys are built with the
+// Search* functions; when several keys are given to Search, a message
+// must match all of them.
+type SearchKey string
+
+// SearchAll matches every message in the mailbox.
+const SearchAll SearchKey = "ALL"
+
+// SearchSeq matches the messages in a sequence set, like "1:5,8".
+func SearchSeq(sequence string) SearchKey {
+	return SearchKey(sequence)
+}
+
+// SearchUID matches the messages with UIDs in a UID set.
+func SearchUID(uids string) SearchKey {
+	return SearchKey("UID " + uids)
+}
+
+// searchQuote quotes a string for a search key.  Non-ASCII strings are
+// sent as literals, since quoted strings must be 7-bit, and make the
+// search use CHARSET UTF-8.
+func searchQuote(s string) string {
+	if is8Bit(s) {
+		return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+	}
+	return quote(s)
+}
+
+func is8Bit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+func searchString(key string, value string) SearchKey {
+	return SearchKey(key + " " + searchQuote(value))
+}
+
+// SearchFrom matches messages whose From header contains s.
+func SearchFrom(s string) SearchKey { return searchString("FROM", s) }
+
+// SearchTo matches messages whose To header contains s.
+func SearchTo(s string) SearchKey { return searchString("TO", s) }
+
+// SearchCc matches messages whose Cc header contains s.
+func SearchCc(s string) SearchKey { return searchString("CC", s) }
+
+// SearchBcc matches messages whose Bcc header contains s.
+func SearchBcc(s string) SearchKey { return searchString("BCC", s) }
+
+// SearchSubject matches messages whose Subject header contains s.
+func SearchSubject(s string) SearchKey { return searchString("SUBJECT", s) }
+
+// SearchBody matches messages whose body contains s.
+func SearchBody(s string) SearchKey { return searchString("BODY", s) }
+
+// SearchText matches messages whose headers or body contain s.
+func SearchText(s string) SearchKey { return searchString("TEXT", s) }
+
+// SearchHeader matches messages with a header field that contains
+// value.  An empty value matches all messages with the field.
+func SearchHeader(field string, value string) SearchKey {
+	return SearchKey("HEADER " + quote(field) + " " + searchQuote(value))
+}
+
+func searchDate(key string, t *time.Time) SearchKey {
+	return SearchKey(key + " " + t.Format("2-Jan-2006"))
+}
+
+// SearchSince matches messages whose internal date is on or after
+// the date of t.
+func SearchSince(t *time.Time) SearchKey { return searchDate("SINCE", t) }
+
+// SearchBefore matches messages whose internal date is before the
+// date of t.
+func SearchBefore(t *time.Time) SearchKey { return searchDate("BEFORE", t) }
+
+// SearchOn matches messages whose internal date is the date of t.
+func SearchOn(t *time.Time) SearchKey { return searchDate("ON", t) }
+
+// SearchLarger matches messages larger than size octets.
+func SearchLarger(size int) SearchKey {
+	return SearchKey(fmt.Sprintf("LARGER %d", size))
+}
+
+// SearchSmaller matches messages smaller than size octets.
+func SearchSmaller(size int) SearchKey {
+	return SearchKey(fmt.Sprintf("SMALLER %d", size))
+}
+
+// SearchModSeq matches messages whose mod-sequence is at least modseq.
+// It requires CONDSTORE (RFC 7162).
+func SearchModSeq(modseq uint64) SearchKey {
+	return SearchKey(fmt.Sprintf("MODSEQ %d", modseq))
+}
+
+// Search keys for the system flags, set and unset.
+var searchFlags = map[Flag][2]string{
+	FlagSeen:     [2]string{"SEEN", "UNSEEN"},
+	FlagAnswered: [2]string{"ANSWERED", "UNANSWERED"},
+	FlagFlagged:  [2]string{"FLAGGED", "UNFLAGGED"},
+	FlagDeleted:  [2]string{"DELETED", "UNDELETED"},
+	FlagDraft:    [2]string{"DRAFT", "UNDRAFT"},
+	FlagRecent:   [2]string{"RECENT", "OLD"},
+}
+
+// SearchFlag matches messages with flag set.
+func SearchFlag(flag Flag) SearchKey {
+	if keys, ok := searchFlags[flag]; ok {
+		return SearchKey(keys[0])
+	}
+	return SearchKey("KEYWORD " + string(flag))
+}
+
+// SearchNoFlag matches messages without flag set.
+func SearchNoFlag(flag Flag) SearchKey {
+	if keys, ok := searchFlags[flag]; ok {
+		return SearchKey(keys[1])
+	}
+	return SearchKey("UNKEYWORD " + string(flag))
+}
+
+// SearchNot matches messages that do not match key.
+func SearchNot(key SearchKey) SearchKey {
+	return "NOT " + key
+}
+
+// SearchOr matches messages that match either a or b.
+func SearchOr(a SearchKey, b SearchKey) SearchKey {
+	return "OR " + SearchAnd(a) + " " + SearchAnd(b)
+}
+
+// SearchAnd matches messages that match all of keys.  It is mostly
+// useful for grouping keys inside SearchNot and SearchOr.
+func SearchAnd(keys ...SearchKey) SearchKey {
+	if len(keys) == 1 {
+		return keys[0]
+	}
+	return "(" + joinSearch(keys) + ")"
+}
+
+func joinSearch(keys []SearchKey) SearchKey {
+	if len(keys) == 0 {
+		return SearchAll
+	}
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = string(key)
+	}
+	return SearchKey(strings.Join(strs, " "))
+}
+
+// searchCriteria joins keys for a SEARCH command, naming the charset
+// if any key has non-ASCII text.
+func searchCriteria(keys []SearchKey) string {
+	criteria := string(joinSearch(keys))
+	if is8Bit(criteria) {
+		criteria = "CHARSET UTF-8 " + criteria
+	}
+	return criteria
+}
+
+func (imap *IMAP) search(command string) ([]uint32, os.Error) {
+	resp, err := imap.SendSync("%s", command)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint32, 0)
+	for _, extra := range resp.extra {
+		if search, ok := extra.(*ResponseSearch); ok {
+			ids = append(ids, search.IDs...)
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	return ids, nil
+}
+
+// Search returns the sequence numbers of the messages in the selected
+// mailbox that match all of keys.  With no keys, all messages match.
+// Text in keys is taken to be UTF-8.
+func (imap *IMAP) Search(keys ...SearchKey) ([]uint32, os.Error) {
+	return imap.search("SEARCH " + searchCriteria(keys))
+}
+
+// UIDSearch is like Search, but returns UIDs.
+func (imap *IMAP) UIDSearch(keys ...SearchKey) ([]uint32, os.Error) {
+	return imap.search("UID SEARCH " + searchCriteria(keys))
+}
+
+// SearchReturn selects a result of ESearch.  See RFC 4731 section 3.1.
+type SearchReturn string
+
+const (
+	SearchReturnMin   SearchReturn = "MIN"
+	SearchReturnMax   SearchReturn = "MAX"
+	SearchReturnCount SearchReturn = "COUNT"
+	SearchReturnAll   SearchReturn = "ALL"
+	// SearchReturnSave keeps the matching messages on the server as
+	// SearchSaved, instead of or in addition to returning them.  It
+	// requires SEARCHRES (RFC 5182).
+	SearchReturnSave SearchReturn = "SAVE"
+)
+
+// SearchSaved refers to the messages saved by the last search with
+// SearchReturnSave.  It can be given in place of a sequence or UID set
+// to commands like Fetch, Store and Copy, or to SearchSeq.
+const SearchSaved = "$"
+
+func (imap *IMAP) esearch(command string, options []SearchReturn, keys []SearchKey) (*ResponseESearch, os.Error) {
+	capability := "ESEARCH"
+	strs := make([]string, len(options))
+	for i, option := range options {
+		if option == SearchReturnSave {
+			capability = "SEARCHRES"
+		}
+		strs[i] = string(option)
+	}
+	hasESearch, err := imap.HasCapability(capability)
+	if err != nil {
+		return nil, err
+	}
+	if !hasESearch {
+		return nil, fmt.Errorf("imap: server does not support %s", capability)
+	}
+
+	tag := fmt.Sprintf("a%d", imap.nextTag)
+	resp, err := imap.SendSync("%s RETURN (%s) %s", command, strings.Join(strs, " "), searchCriteria(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	// The server may leave out the ESEARCH response when nothing
+	// matches or only SAVE was requested.  Responses tagged for other
+	// commands are not ours.
+	var search *ResponseESearch
+	for _, extra := range resp.extra {
+		s, ok := extra.(*ResponseESearch)
+		if ok && search == nil && (s.Tag == tag || s.Tag == "") {
+			search = s
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	if search == nil {
+		search = &ResponseESearch{UID: strings.HasPrefix(command, "UID ")}
+	}
+	return search, nil
+}
+
+// ESearch is like Search, but returns only the results in options
+// (RFC 4731), which is much shorter than the full list on a large
+// mailbox.  With no options, the server returns All.
+func (imap *IMAP) ESearch(options []SearchReturn, keys ...SearchKey) (*ResponseESearch, os.Error) {
+	return imap.esearch("SEARCH", options, keys)
+}
+
+// UIDESearch is like ESearch, but returns UIDs.
+func (imap *IMAP) UIDESearch(options []SearchReturn, keys ...SearchKey) (*ResponseESearch, os.Error) {
+	return imap.esearch("UID SEARCH", options, keys)
+}
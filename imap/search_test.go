@@ -0,0 +1,40 @@
+package imap
+
+import (
+	"testing"
+)
+
+func TestSearchKeys(t *testing.T) {
+	tests := []struct {
+		keys     []SearchKey
+		expected SearchKey
+	}{
+		{nil, "ALL"},
+		{[]SearchKey{SearchSeq("1:5"), SearchFrom("Dan Ballard")}, `1:5 FROM "Dan Ballard"`},
+		{[]SearchKey{SearchFlag(FlagSeen), SearchNoFlag("$Junk")}, "SEEN UNKEYWORD $Junk"},
+		{[]SearchKey{SearchNot(SearchFlag(FlagRecent))}, "NOT RECENT"},
+		{
+			[]SearchKey{SearchOr(SearchSubject(`say "hi"`), SearchAnd(SearchLarger(100), SearchSmaller(200)))},
+			`OR SUBJECT "say \"hi\"" (LARGER 100 SMALLER 200)`,
+		},
+		{[]SearchKey{SearchUID("300:*"), SearchHeader("X-Spam", "")}, `UID 300:* HEADER "X-Spam" ""`},
+		{[]SearchKey{SearchSeq(SearchSaved), SearchNot(SearchFlag(FlagSeen))}, "$ NOT SEEN"},
+	}
+
+	for _, test := range tests {
+		if key := joinSearch(test.keys); key != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, key)
+		}
+	}
+}
+
+func TestSearchCharset(t *testing.T) {
+	keys := []SearchKey{SearchFrom("J\xc3\xbcrgen"), SearchSubject("hi")}
+	expected := "CHARSET UTF-8 FROM {7}\r\nJ\xc3\xbcrgen SUBJECT \"hi\""
+	if criteria := searchCriteria(keys); criteria != expected {
+		t.Errorf("expected %q, got %q", expected, criteria)
+	}
+	if criteria := searchCriteria([]SearchKey{SearchSubject("hi")}); criteria != `SUBJECT "hi"` {
+		t.Errorf("unexpected charset in %q", criteria)
+	}
+}
@@ -0,0 +1,89 @@
+package imap
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SeqRange is a range of message sequence numbers or UIDs, from Start
+// to Stop inclusive.  A Stop of 0 stands for "*", the largest number
+// in use.
+type SeqRange struct {
+	Start, Stop uint32
+}
+
+// SeqSet is a set of message sequence numbers or UIDs, like "1:3,5",
+// kept in the compact form the server sent.
+type SeqSet []SeqRange
+
+func formatSeqNum(n uint32) string {
+	if n == 0 {
+		return "*"
+	}
+	return strconv.Itoa(int(n))
+}
+
+func (s SeqSet) String() string {
+	strs := make([]string, len(s))
+	for i, r := range s {
+		if r.Start == r.Stop {
+			strs[i] = formatSeqNum(r.Start)
+		} else {
+			strs[i] = formatSeqNum(r.Start) + ":" + formatSeqNum(r.Stop)
+		}
+	}
+	return strings.Join(strs, ",")
+}
+
+// Contains reports whether n is in the set.  Ranges ending in "*" are
+// taken to have no end.
+func (s SeqSet) Contains(n uint32) bool {
+	for _, r := range s {
+		start, stop := r.Start, r.Stop
+		if start > stop && stop != 0 {
+			start, stop = stop, start
+		}
+		if n >= start && (stop == 0 || n <= stop) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSeqNum(str string) (uint32, os.Error) {
+	if str == "*" {
+		return 0, nil
+	}
+	n, err := strconv.Atoui64(str)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 || n > 0xffffffff {
+		return 0, fmt.Errorf("imap: bad sequence number %q", str)
+	}
+	return uint32(n), nil
+}
+
+// ParseSeqSet parses a sequence set, like "1:3,5".
+func ParseSeqSet(str string) (SeqSet, os.Error) {
+	parts := strings.Split(str, ",")
+	set := make(SeqSet, len(parts))
+	for i, part := range parts {
+		bounds := strings.SplitN(part, ":", 2)
+		start, err := parseSeqNum(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		stop := start
+		if len(bounds) == 2 {
+			stop, err = parseSeqNum(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		set[i] = SeqRange{start, stop}
+	}
+	return set, nil
+}
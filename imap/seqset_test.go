@@ -0,0 +1,46 @@
+package imap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeqSet(t *testing.T) {
+	tests := []struct {
+		str string
+		set SeqSet
+	}{
+		{"5", SeqSet{{5, 5}}},
+		{"41,43:116,118", SeqSet{{41, 41}, {43, 116}, {118, 118}}},
+		{"300:*", SeqSet{{300, 0}}},
+	}
+
+	for _, test := range tests {
+		set, err := ParseSeqSet(test.str)
+		testError(t, err, "parsing %q", test.str)
+		if !reflect.DeepEqual(set, test.set) {
+			t.Fatalf("DeepEqual(%#v, %#v)", set, test.set)
+		}
+		if str := set.String(); str != test.str {
+			t.Fatalf("expected %q, got %q", test.str, str)
+		}
+	}
+
+	set := SeqSet{{41, 41}, {116, 43}, {300, 0}}
+	for _, n := range []uint32{41, 43, 100, 116, 300, 5000} {
+		if !set.Contains(n) {
+			t.Errorf("%s should contain %d", set, n)
+		}
+	}
+	for _, n := range []uint32{1, 42, 117, 299} {
+		if set.Contains(n) {
+			t.Errorf("%s should not contain %d", set, n)
+		}
+	}
+
+	for _, bad := range []string{"", "0", "1:x", "1,,2"} {
+		if _, err := ParseSeqSet(bad); err == nil {
+			t.Errorf("parsing %q should fail", bad)
+		}
+	}
+}
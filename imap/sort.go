@@ -0,0 +1,127 @@
+package imap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SortKey is a criterion for Sort.  See RFC 5256 section 3.
+type SortKey string
+
+const (
+	SortArrival SortKey = "ARRIVAL"
+	SortCc      SortKey = "CC"
+	SortDate    SortKey = "DATE"
+	SortFrom    SortKey = "FROM"
+	SortSize    SortKey = "SIZE"
+	SortSubject SortKey = "SUBJECT"
+	SortTo      SortKey = "TO"
+)
+
+// SortReverse sorts by key in descending order.
+func SortReverse(key SortKey) SortKey {
+	return "REVERSE " + key
+}
+
+// ThreadAlgorithm selects how Thread groups messages.
+type ThreadAlgorithm string
+
+const (
+	// ThreadOrderedSubject groups messages by base subject.
+	ThreadOrderedSubject ThreadAlgorithm = "ORDEREDSUBJECT"
+	// ThreadReferences follows the References and In-Reply-To
+	// headers.
+	ThreadReferences ThreadAlgorithm = "REFERENCES"
+)
+
+func defaultCharset(charset string) string {
+	if charset == "" {
+		return "UTF-8"
+	}
+	return charset
+}
+
+func (imap *IMAP) sort(command string, criteria []SortKey, charset string, keys []SearchKey) ([]uint32, os.Error) {
+	hasSort, err := imap.HasCapability("SORT")
+	if err != nil {
+		return nil, err
+	}
+	if !hasSort {
+		return nil, os.NewError("imap: server does not support SORT")
+	}
+	if len(criteria) == 0 {
+		return nil, os.NewError("imap: no sort criteria")
+	}
+
+	strs := make([]string, len(criteria))
+	for i, key := range criteria {
+		strs[i] = string(key)
+	}
+	resp, err := imap.SendSync("%s (%s) %s %s", command, strings.Join(strs, " "),
+		defaultCharset(charset), joinSearch(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint32, 0)
+	for _, extra := range resp.extra {
+		if sort, ok := extra.(*ResponseSort); ok {
+			ids = append(ids, sort.IDs...)
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	return ids, nil
+}
+
+// Sort returns the sequence numbers of the messages in the selected
+// mailbox that match all of keys, ordered by criteria.  An empty
+// charset means UTF-8.
+func (imap *IMAP) Sort(criteria []SortKey, charset string, keys ...SearchKey) ([]uint32, os.Error) {
+	return imap.sort("SORT", criteria, charset, keys)
+}
+
+// UIDSort is like Sort, but returns UIDs.
+func (imap *IMAP) UIDSort(criteria []SortKey, charset string, keys ...SearchKey) ([]uint32, os.Error) {
+	return imap.sort("UID SORT", criteria, charset, keys)
+}
+
+func (imap *IMAP) thread(command string, algorithm ThreadAlgorithm, charset string, keys []SearchKey) ([]*ThreadNode, os.Error) {
+	hasThread, err := imap.HasCapability("THREAD=" + string(algorithm))
+	if err != nil {
+		return nil, err
+	}
+	if !hasThread {
+		return nil, fmt.Errorf("imap: server does not support THREAD=%s", algorithm)
+	}
+
+	resp, err := imap.SendSync("%s %s %s %s", command, algorithm,
+		defaultCharset(charset), joinSearch(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	threads := make([]*ThreadNode, 0)
+	for _, extra := range resp.extra {
+		if thread, ok := extra.(*ResponseThread); ok {
+			threads = append(threads, thread.Threads...)
+		} else {
+			imap.Unsolicited <- extra
+		}
+	}
+	return threads, nil
+}
+
+// Thread returns the messages in the selected mailbox that match all
+// of keys, grouped into conversations by algorithm.  Each returned node
+// is the root of one thread, identified by sequence number.  An empty
+// charset means UTF-8.
+func (imap *IMAP) Thread(algorithm ThreadAlgorithm, charset string, keys ...SearchKey) ([]*ThreadNode, os.Error) {
+	return imap.thread("THREAD", algorithm, charset, keys)
+}
+
+// UIDThread is like Thread, but identifies messages by UID.
+func (imap *IMAP) UIDThread(algorithm ThreadAlgorithm, charset string, keys ...SearchKey) ([]*ThreadNode, os.Error) {
+	return imap.thread("UID THREAD", algorithm, charset, keys)
+}
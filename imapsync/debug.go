@@ -3,6 +3,7 @@ package main
 import (
 	"io"
 	"log"
+	"net"
 	"os"
 )
 
@@ -34,3 +35,20 @@ func (r *LoggingReader) Read(p []byte) (int, os.Error) {
 	}
 	return n, err
 }
+
+// readerConn is a connection whose incoming data is read through r,
+// so that it can be logged or measured.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, os.Error) {
+	return c.r.Read(p)
+}
+
+// NetConn returns the wrapped connection, so that the imap package can
+// tell whether it uses TLS.
+func (c *readerConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -9,10 +9,13 @@ import (
 	"log"
 	"flag"
 	"fmt"
+	"net"
 	"time"
 )
 
 var dumpProtocol *bool = flag.Bool("dumpprotocol", false, "dump imap stream")
+var server *string = flag.String("server", "imap.gmail.com:993", "imap server address")
+var startTLS *bool = flag.Bool("starttls", false, "connect in plaintext and use STARTTLS")
 
 func check(err os.Error) {
 	if err != nil {
@@ -74,24 +77,48 @@ func (ui *UI) connect(useNetmon bool) *imap.IMAP {
 	user, pass := loadAuth("auth")
 
 	ui.log("connecting...")
-	conn, err := tls.Dial("tcp", "imap.gmail.com:993", nil)
+	host, _, err := net.SplitHostPort(*server)
+	check(err)
+	var conn net.Conn
+	if *startTLS {
+		conn, err = net.Dial("tcp", *server)
+	} else {
+		conn, err = tls.Dial("tcp", *server, nil)
+	}
 	check(err)
 
-	var r io.Reader = conn
-	if *dumpProtocol {
-		r = newLoggingReader(r, 300)
+	// Log and measure above TLS, so that with -starttls the dump
+	// shows the protocol rather than ciphertext.
+	wrap := func(conn net.Conn) net.Conn {
+		var r io.Reader = conn
+		if *dumpProtocol {
+			r = newLoggingReader(r, 300)
+		}
+		if useNetmon {
+			ui.netmon = newNetmonReader(r)
+			r = ui.netmon
+		}
+		return &readerConn{conn, r}
 	}
-	if useNetmon {
-		ui.netmon = newNetmonReader(r)
-		r = ui.netmon
+
+	var im *imap.IMAP
+	if *startTLS {
+		im = imap.New(conn)
+		im.WrapTLS = wrap
+	} else {
+		im = imap.New(wrap(conn))
 	}
-	im := imap.New(r, conn)
 	im.Unsolicited = make(chan interface{}, 100)
 
 	hello, err := im.Start()
 	check(err)
 	ui.log("server hello: %s", hello)
 
+	if *startTLS {
+		ui.log("starting TLS...")
+		check(im.StartTLS(&tls.Config{ServerName: host}))
+	}
+
 	ui.log("logging in...")
 	resp, caps, err := im.Auth(user, pass)
 	check(err)
@@ -125,7 +152,10 @@ func (ui *UI) fetch(im *imap.IMAP, mailbox string) {
 	ui.progress(i, total, "fetching messages", i, total)
 L:
 	for {
-		r := <-ch
+		r, ok := <-ch
+		if !ok {
+			panic(imap.ErrClosed)
+		}
 		switch r := r.(type) {
 		case *imap.ResponseFetch:
 			mbox.writeMessage("imapsync@none", envelopeDate, r.Rfc822)
@@ -149,6 +179,7 @@ func (ui *UI) runFetch(mailbox string) {
 		}()
 		im := ui.connect(true)
 		ui.fetch(im, mailbox)
+		check(im.Logout())
 		close(ui.statusChan)
 	}()
 
@@ -221,13 +252,34 @@ func main() {
 	switch mode {
 	case "list":
 		im := ui.connect(false)
-		mailboxes, err := im.List("", imap.WildcardAny)
+		// Always list from the root too: with a personal namespace
+		// like "INBOX.", INBOX itself is outside every prefix.
+		prefixes := []string{""}
+		hasNamespace, err := im.HasCapability("NAMESPACE")
 		check(err)
+		if hasNamespace {
+			ns, err := im.Namespace()
+			check(err)
+			seen := map[string]bool{"": true}
+			for _, list := range [][]imap.Namespace{ns.Personal, ns.Other, ns.Shared} {
+				for _, n := range list {
+					if !seen[n.Prefix] {
+						seen[n.Prefix] = true
+						prefixes = append(prefixes, n.Prefix)
+					}
+				}
+			}
+		}
 		fmt.Printf("Available mailboxes:\n")
-		for _, mailbox := range mailboxes {
-			fmt.Printf("  %s\n", mailbox.Name)
+		for _, prefix := range prefixes {
+			mailboxes, err := im.List(prefix, imap.WildcardAny)
+			check(err)
+			for _, mailbox := range mailboxes {
+				fmt.Printf("  %s\n", mailbox.Name)
+			}
 		}
 		readExtra(im)
+		check(im.Logout())
 	case "fetch":
 		if len(args) < 1 {
 			fmt.Printf("must specify mailbox to fetch\n")